@@ -5,6 +5,7 @@ import (
 
 	"multi-client-whatsapp/internal/instance"
 	"multi-client-whatsapp/internal/platform/router"
+	"multi-client-whatsapp/internal/services"
 
 	"github.com/joho/godotenv"
 )
@@ -21,6 +22,13 @@ func main() {
 	// Setup and run router
 	r := router.SetupRouter()
 
+	// Start the outbound queue and the scheduler, both replay stored send requests through the router
+	services.StartQueue(r)
+	services.StartScheduler(r)
+
+	// Start the campaign runner, it feeds campaign messages into the outbound queue
+	services.StartCampaigns()
+
 	// Start server
 	log.Println("Starting Multi-Instance Go WhatsApp Bridge on port 4444")
 	if err := r.Run(":4444"); err != nil {
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"sync"
 
 	"github.com/lib/pq"
 	"go.mau.fi/whatsmeow/store/sqlstore"
@@ -75,3 +76,202 @@ func DropDatabase(instanceKey string) {
 		}
 	}
 }
+
+// bridgeDBName is the database holding the bridge's own state (scheduled
+// messages, the outbound queue, campaigns, templates, ...), shared by all instances. It can't clash with instance
+// databases since instance keys are hex strings.
+const bridgeDBName = "whatsapp_bridge"
+
+// bridgeSchema creates the bridge tables, every statement must be idempotent
+var bridgeSchema = []string{
+	`CREATE TABLE IF NOT EXISTS scheduled_messages (
+		id              TEXT PRIMARY KEY,
+		instance_key    TEXT NOT NULL,
+		endpoint        TEXT NOT NULL,
+		payload         JSONB NOT NULL,
+		send_at         TIMESTAMPTZ NOT NULL,
+		starts_at       TIMESTAMPTZ NOT NULL,
+		recurrence      TEXT NOT NULL DEFAULT '',
+		timezone        TEXT NOT NULL DEFAULT 'UTC',
+		status          TEXT NOT NULL DEFAULT 'pending',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		run_count       INTEGER NOT NULL DEFAULT 0,
+		last_error      TEXT NOT NULL DEFAULT '',
+		last_message_id TEXT NOT NULL DEFAULT '',
+		last_run_at     TIMESTAMPTZ,
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages (status, send_at)`,
+	`CREATE INDEX IF NOT EXISTS scheduled_messages_instance_idx ON scheduled_messages (instance_key)`,
+	`ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS last_queue_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS offline_retries INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS outbound_queue (
+		id              TEXT PRIMARY KEY,
+		instance_key    TEXT NOT NULL,
+		endpoint        TEXT NOT NULL,
+		payload         JSONB NOT NULL,
+		priority        INTEGER NOT NULL DEFAULT 1,
+		status          TEXT NOT NULL DEFAULT 'pending',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		response_code   INTEGER,
+		response        JSONB,
+		message_id      TEXT NOT NULL DEFAULT '',
+		last_error      TEXT NOT NULL DEFAULT '',
+		created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		sent_at         TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS outbound_queue_next_idx ON outbound_queue (instance_key, status, priority DESC, created_at)`,
+	`CREATE INDEX IF NOT EXISTS outbound_queue_due_idx ON outbound_queue (status, next_attempt_at)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		instance_key  TEXT NOT NULL,
+		key           TEXT NOT NULL,
+		request_hash  TEXT NOT NULL,
+		status        TEXT NOT NULL,
+		response_code INTEGER,
+		response      JSONB,
+		created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		expires_at    TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (instance_key, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idempotency_keys_expires_idx ON idempotency_keys (expires_at)`,
+	`CREATE TABLE IF NOT EXISTS campaigns (
+		id            TEXT PRIMARY KEY,
+		name          TEXT NOT NULL,
+		instance_keys JSONB NOT NULL,
+		message_type  TEXT NOT NULL,
+		message       JSONB NOT NULL,
+		priority      TEXT NOT NULL,
+		status        TEXT NOT NULL,
+		start_at      TIMESTAMPTZ NOT NULL,
+		created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		started_at    TIMESTAMPTZ,
+		completed_at  TIMESTAMPTZ
+	)`,
+	`CREATE TABLE IF NOT EXISTS campaign_recipients (
+		id             BIGSERIAL PRIMARY KEY,
+		campaign_id    TEXT NOT NULL REFERENCES campaigns (id) ON DELETE CASCADE,
+		phone          TEXT NOT NULL,
+		variables      JSONB NOT NULL DEFAULT '{}',
+		status         TEXT NOT NULL DEFAULT 'pending',
+		resolved_phone TEXT NOT NULL DEFAULT '',
+		instance_key   TEXT NOT NULL DEFAULT '',
+		queue_id       TEXT NOT NULL DEFAULT '',
+		message_id     TEXT NOT NULL DEFAULT '',
+		error          TEXT NOT NULL DEFAULT '',
+		updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS campaign_recipients_campaign_idx ON campaign_recipients (campaign_id, status, id)`,
+	`CREATE INDEX IF NOT EXISTS campaign_recipients_message_idx ON campaign_recipients (message_id) WHERE message_id <> ''`,
+	`CREATE INDEX IF NOT EXISTS campaign_recipients_queue_idx ON campaign_recipients (queue_id) WHERE queue_id <> ''`,
+	`CREATE INDEX IF NOT EXISTS outbound_queue_message_idx ON outbound_queue (message_id) WHERE message_id <> ''`,
+	`CREATE TABLE IF NOT EXISTS message_templates (
+		name         TEXT NOT NULL,
+		version      INTEGER NOT NULL,
+		message_type TEXT NOT NULL,
+		message      JSONB NOT NULL,
+		variables    JSONB NOT NULL DEFAULT '[]',
+		description  TEXT NOT NULL DEFAULT '',
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (name, version)
+	)`,
+	`CREATE TABLE IF NOT EXISTS message_status (
+		instance_key  TEXT NOT NULL,
+		message_id    TEXT NOT NULL,
+		chat          TEXT NOT NULL,
+		status        TEXT NOT NULL,
+		sent_at       TIMESTAMPTZ NOT NULL,
+		server_ack_at TIMESTAMPTZ,
+		delivered_at  TIMESTAMPTZ,
+		read_at       TIMESTAMPTZ,
+		played_at     TIMESTAMPTZ,
+		updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (instance_key, message_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS message_status_chat_idx ON message_status (instance_key, chat, sent_at DESC)`,
+	`CREATE INDEX IF NOT EXISTS message_status_sent_idx ON message_status (sent_at)`,
+	`CREATE TABLE IF NOT EXISTS message_status_participants (
+		instance_key TEXT NOT NULL,
+		message_id   TEXT NOT NULL,
+		participant  TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		delivered_at TIMESTAMPTZ,
+		read_at      TIMESTAMPTZ,
+		played_at    TIMESTAMPTZ,
+		updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (instance_key, message_id, participant),
+		FOREIGN KEY (instance_key, message_id) REFERENCES message_status ON DELETE CASCADE
+	)`,
+	`CREATE TABLE IF NOT EXISTS message_metadata (
+		instance_key TEXT NOT NULL,
+		message_id   TEXT NOT NULL,
+		metadata     JSONB NOT NULL,
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (instance_key, message_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS queue_settings (
+		instance_key    TEXT PRIMARY KEY,
+		rate_per_minute INTEGER NOT NULL,
+		jitter_ms       INTEGER NOT NULL,
+		daily_cap       INTEGER NOT NULL,
+		updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+}
+
+var (
+	bridgeDB    *sql.DB
+	bridgeMutex sync.Mutex
+)
+
+// BridgeDB returns the connection pool of the shared bridge database,
+// creating the database and its tables on first use. Failures aren't cached,
+// so a database that comes up late is picked up on the next call.
+func BridgeDB() (*sql.DB, error) {
+	bridgeMutex.Lock()
+	defer bridgeMutex.Unlock()
+
+	if bridgeDB != nil {
+		return bridgeDB, nil
+	}
+
+	dbDriver := os.Getenv("DB_DRIVER")
+	dbURL := os.Getenv("DB_URL")
+
+	maintenance, err := sql.Open(dbDriver, dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("error opening maintenance database: %w", err)
+	}
+	defer maintenance.Close()
+
+	_, err = maintenance.Exec(fmt.Sprintf(`CREATE DATABASE "%s"`, bridgeDBName))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); !ok || pqErr.Code != "42P04" { // 42P04 is duplicate_database
+			return nil, fmt.Errorf("error creating database %s: %w", bridgeDBName, err)
+		}
+	} else {
+		log.Printf("Successfully created database %s", bridgeDBName)
+	}
+
+	parsedURL, err := url.Parse(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing DB URL: %w", err)
+	}
+	parsedURL.Path = "/" + bridgeDBName
+
+	db, err := sql.Open(dbDriver, parsedURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("error opening database %s: %w", bridgeDBName, err)
+	}
+	for _, statement := range bridgeSchema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error migrating database %s: %w", bridgeDBName, err)
+		}
+	}
+
+	bridgeDB = db
+	return bridgeDB, nil
+}
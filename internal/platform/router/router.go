@@ -7,6 +7,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// send chains the middleware shared by all send endpoints in front of a send handler
+func send(handler gin.HandlerFunc) []gin.HandlerFunc {
+	return []gin.HandlerFunc{handlers.IdempotentSend, handlers.CheckMessageMetadata, handlers.ScheduleMessage, handlers.QueueMessage, handlers.TrackMessageMetadata, handler}
+}
+
 func SetupRouter() *gin.Engine {
 	r := gin.Default()
 
@@ -31,18 +36,91 @@ func SetupRouter() *gin.Engine {
 	// Delete instance endpoint
 	r.DELETE("/instance/:instanceKey", handlers.DeleteInstance)
 
+	// Poll results endpoint
+	r.GET("/instance/:instanceKey/poll/:messageId", handlers.GetPollResults)
+
+	// Delivery status of sent messages
+	r.GET("/instance/:instanceKey/message/:messageId/status", handlers.GetMessageStatus)
+	r.GET("/instance/:instanceKey/chat/:chat/messages", handlers.ListChatMessageStatuses)
+
+	// Toggle automatic read receipts for inbound messages
+	r.POST("/instance/:instanceKey/auto-read", handlers.SetAutoRead)
+
+	// Webhook destinations of an instance
+	r.GET("/instance/:instanceKey/webhooks", handlers.GetWebhooks)
+	r.PUT("/instance/:instanceKey/webhooks", handlers.SetWebhooks)
+	r.DELETE("/instance/:instanceKey/webhooks", handlers.ResetWebhooks)
+
+	// Scheduled messages endpoints
+	r.GET("/instance/:instanceKey/scheduled", handlers.ListScheduledMessages)
+	r.GET("/instance/:instanceKey/scheduled/:scheduleId", handlers.GetScheduledMessage)
+	r.PATCH("/instance/:instanceKey/scheduled/:scheduleId", handlers.UpdateScheduledMessage)
+	r.DELETE("/instance/:instanceKey/scheduled/:scheduleId", handlers.CancelScheduledMessage)
+
+	// Outbound queue endpoints
+	r.GET("/instance/:instanceKey/queue", handlers.ListQueuedMessages)
+	r.GET("/instance/:instanceKey/queue/settings", handlers.GetQueueSettings)
+	r.PUT("/instance/:instanceKey/queue/settings", handlers.UpdateQueueSettings)
+	r.GET("/instance/:instanceKey/queue/:queueId", handlers.GetQueuedMessage)
+	r.DELETE("/instance/:instanceKey/queue/:queueId", handlers.CancelQueuedMessage)
+
+	// Campaign endpoints
+	r.POST("/campaign/create", handlers.CreateCampaign)
+	r.GET("/campaigns", handlers.ListCampaigns)
+	r.GET("/campaign/:campaignId", handlers.GetCampaign)
+	r.POST("/campaign/:campaignId/pause", handlers.PauseCampaign)
+	r.POST("/campaign/:campaignId/resume", handlers.ResumeCampaign)
+	r.POST("/campaign/:campaignId/cancel", handlers.CancelCampaign)
+	r.GET("/campaign/:campaignId/recipients", handlers.GetCampaignRecipients)
+	r.GET("/campaign/:campaignId/report", handlers.ExportCampaignReport)
+
+	// Message template endpoints
+	r.POST("/template/create", handlers.CreateTemplate)
+	r.GET("/templates", handlers.ListTemplates)
+	r.GET("/template/:name", handlers.GetTemplate)
+	r.PUT("/template/:name", handlers.UpdateTemplate)
+	r.DELETE("/template/:name", handlers.DeleteTemplate)
+	r.GET("/template/:name/versions", handlers.ListTemplateVersions)
+
 	// Phone validation endpoint
 	r.POST("/phone/validate", handlers.ValidatePhone)
 	r.POST("/phone/test-exists", handlers.TestPhoneExists)
 	r.POST("/phone/lid-to-phone", handlers.ConvertLIDToPhone)
 
-	// Message sending endpoints
-	r.POST("/message/send", handlers.SendTextMessage)
-	r.POST("/message/send-media", handlers.SendMediaMessage)
-	r.POST("/message/send-contact", handlers.SendContactMessage)
-	r.POST("/message/send-voice", handlers.SendVoiceMessage)
-	r.POST("/message/send-location", handlers.SendLocationMessage)
-	r.POST("/message/send-interactive", handlers.SendInteractiveMessage)
+	// Message sending endpoints. All of them accept an idempotency key and
+	// send_at/recurrence to schedule the message, and go through the
+	// instance's outbound queue.
+	r.POST("/message/send", send(handlers.SendTextMessage)...)
+	r.POST("/message/send-media", send(handlers.SendMediaMessage)...)
+	r.POST("/message/send-contact", send(handlers.SendContactMessage)...)
+	r.POST("/message/send-voice", send(handlers.SendVoiceMessage)...)
+	r.POST("/message/send-album", send(handlers.SendAlbumMessage)...)
+	r.POST("/message/send-location", send(handlers.SendLocationMessage)...)
+	r.POST("/message/live-location/update", handlers.UpdateLiveLocation)
+	r.POST("/message/send-interactive", send(handlers.SendInteractiveMessage)...)
+	r.POST("/message/send-poll", send(handlers.SendPollMessage)...)
+	r.POST("/message/forward", send(handlers.ForwardMessage)...)
+
+	// Template sends check the template before the message is scheduled or
+	// queued, and pin its version so replays render the same message
+	r.POST("/message/send-template", handlers.IdempotentSend, handlers.PrepareTemplateMessage, handlers.CheckMessageMetadata,
+		handlers.ScheduleMessage, handlers.QueueMessage, handlers.TrackMessageMetadata, handlers.SendTemplateMessage)
+
+	// Status (stories) endpoints, scheduled and queued like messages
+	r.POST("/status/send-text", send(handlers.SendTextStatus)...)
+	r.POST("/status/send-media", send(handlers.SendMediaStatus)...)
+	r.GET("/instance/:instanceKey/status-privacy", handlers.GetStatusPrivacy)
+
+	r.POST("/message/revoke", handlers.RevokeMessage)
+	r.POST("/message/mark-read", handlers.MarkMessagesRead)
+
+	// Disappearing messages endpoints
+	r.POST("/chat/disappearing", handlers.SetDisappearingTimer)
+	r.PUT("/instance/:instanceKey/disappearing", handlers.SetDefaultDisappearingTimer)
+
+	// Presence endpoints
+	r.POST("/presence/chat", handlers.SetChatPresence)
+	r.POST("/presence/status", handlers.SetPresence)
 
 	// Webhook endpoint for incoming messages
 	r.POST("/webhook", handlers.HandleWebhook)
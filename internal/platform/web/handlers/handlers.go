@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -205,6 +208,7 @@ func GetInstanceStatus(c *gin.Context) {
 		"connected":    inst.IsConnected,
 		"logged_in":    inst.Client.IsLoggedIn(),
 		"phone_number": inst.PhoneNumber,
+		"auto_read":    inst.AutoRead,
 	})
 }
 
@@ -289,6 +293,9 @@ func DeleteInstance(c *gin.Context) {
 	inst.IsConnected = false
 	inst.Mutex.Unlock()
 
+	// Keep the webhook destinations for the deletion webhook, they go with the instance
+	webhooks := services.InstanceWebhookDestinations(inst)
+
 	// Remove from instance manager
 	instance.Manager.Mutex.Lock()
 	delete(instance.Manager.Instances, instanceKey)
@@ -297,6 +304,15 @@ func DeleteInstance(c *gin.Context) {
 	// Now, drop the database
 	database.DropDatabase(instanceKey)
 
+	services.ForgetUnreadMessages(instanceKey)
+	services.ForgetCachedMessages(instanceKey)
+	services.ForgetPolls(instanceKey)
+	services.ForgetLiveLocations(instanceKey)
+	services.DeleteScheduledMessages(instanceKey)
+	services.DeleteQueuedMessages(instanceKey)
+	services.DeleteIdempotencyKeys(instanceKey)
+	services.DeleteMessageStatuses(instanceKey)
+
 	// Delete media directory for this instance
 	mediaDir := fmt.Sprintf("/app/media/%s", instanceKey)
 	if err := os.RemoveAll(mediaDir); err != nil {
@@ -312,7 +328,7 @@ func DeleteInstance(c *gin.Context) {
 		"status":       "deleted",
 		"timestamp":    time.Now(),
 	}
-	services.SendWebhook("instance_deleted", deletionData, instanceKey)
+	services.SendWebhookTo(webhooks, "instance_deleted", deletionData, instanceKey)
 
 	c.JSON(200, gin.H{
 		"status":       "deleted",
@@ -535,6 +551,11 @@ func SendTextMessage(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
 	instance.Manager.Mutex.RLock()
 	inst, exists := instance.Manager.Instances[req.InstanceKey]
 	instance.Manager.Mutex.RUnlock()
@@ -547,22 +568,22 @@ func SendTextMessage(c *gin.Context) {
 	inst.Mutex.RLock()
 	if !inst.IsConnected {
 		inst.Mutex.RUnlock()
-		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		instanceNotConnected(c)
 		return
 	}
 	inst.Mutex.RUnlock()
 
-	// Validate and correct phone number
-	validPhone, err := services.ValidateAndCorrectPhone(req.Phone, inst)
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
 		return
 	}
 
-	// Parse phone number to JID
-	recipient, err := whatsappTypes.ParseJID(validPhone)
+	// Resolve mentions before doing any work
+	mentionedJIDs, err := services.ResolveMentions(inst, recipient, req.Message, req.Mentions, req.MentionAll)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid mentions: %v", err)})
 		return
 	}
 
@@ -573,19 +594,42 @@ func SendTextMessage(c *gin.Context) {
 		},
 	}
 
+	// Add link preview if requested
+	if req.LinkPreview || req.Preview != nil {
+		preview, thumbnail, err := services.BuildLinkPreview(req.Message, req.Preview)
+		if err != nil {
+			// A missing preview shouldn't stop the message from going out
+			log.Printf("Error building link preview: %v", err)
+		} else {
+			services.ApplyLinkPreview(msg.ExtendedTextMessage, preview, thumbnail)
+		}
+	}
+
 	// Add reply context if provided
-	if req.ReplyTo != "" {
-		msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: proto.String(req.ReplyTo),
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+
+	// Add mentions if provided
+	if len(mentionedJIDs) > 0 {
+		services.EnsureContextInfo(msg).MentionedJID = mentionedJIDs
+	}
+
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
+
+	// Show the typing indicator before sending if requested
+	if req.SimulateTyping {
+		if err := services.SimulateTyping(inst, recipient, req.Message); err != nil {
+			log.Printf("Error simulating typing for %s: %v", recipient.String(), err)
 		}
 	}
 
 	// Send message
-	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
 
 	c.JSON(200, types.MessageResponse{
 		Status:    "sent",
@@ -600,6 +644,15 @@ func SendMediaMessage(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ViewOnce && req.Type != "image" && req.Type != "video" && !(req.Type == "audio" && req.IsPTT) {
+		c.JSON(400, gin.H{"error": "view_once is only supported for images, videos and voice notes"})
+		return
+	}
+
 	instance.Manager.Mutex.RLock()
 	inst, exists := instance.Manager.Instances[req.InstanceKey]
 	instance.Manager.Mutex.RUnlock()
@@ -612,183 +665,72 @@ func SendMediaMessage(c *gin.Context) {
 	inst.Mutex.RLock()
 	if !inst.IsConnected {
 		inst.Mutex.RUnlock()
-		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		instanceNotConnected(c)
 		return
 	}
 	inst.Mutex.RUnlock()
 
-	// Validate and correct phone number
-	validPhone, err := services.ValidateAndCorrectPhone(req.Phone, inst)
-	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
-		return
-	}
-
-	// Parse phone number to JID
-	recipient, err := whatsappTypes.ParseJID(validPhone)
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
 		return
 	}
 
-	// Download media from URL
-	httpResp, err := http.Get(req.URL)
+	// Resolve mentions before doing any work
+	mentionedJIDs, err := services.ResolveMentions(inst, recipient, req.Caption, req.Mentions, req.MentionAll)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to download media from URL"})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid mentions: %v", err)})
 		return
 	}
-	defer httpResp.Body.Close()
 
-	if httpResp.StatusCode != http.StatusOK {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to download media: %d", httpResp.StatusCode)})
+	if req.Type != "image" && req.Type != "audio" && req.Type != "video" && req.Type != "file" {
+		c.JSON(400, gin.H{"error": "Invalid media type"})
 		return
 	}
 
-	mediaData, err := io.ReadAll(httpResp.Body)
+	// Download the media and upload it to WhatsApp
+	mediaData, err := services.DownloadMedia(req.URL)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to read media data"})
+		failBeforeSend(c, mediaErrorStatus(err), err)
 		return
 	}
-
-	// Create media directory if it doesn't exist
-	mediaDir := "./media"
-	if err := os.MkdirAll(mediaDir, 0755); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create media directory"})
+	msg, mediaHandle, err := services.BuildMediaMessage(inst, recipient, mediaData, req.Type, services.MediaOptions{
+		Caption:  req.Caption,
+		FileName: fmt.Sprintf("%s_%d", req.Type, time.Now().Unix()),
+		PTT:      req.IsPTT,
+	})
+	if err != nil {
+		failBeforeSend(c, mediaErrorStatus(err), err)
 		return
 	}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("%s_%d", req.Type, time.Now().Unix())
-	var filepath string
-	var msg *waE2E.Message
-
-	switch req.Type {
-	case "image":
-		filepath = fmt.Sprintf("%s/%s.jpg", mediaDir, filename)
-		if err := os.WriteFile(filepath, mediaData, 0644); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to save image"})
-			return
-		}
-		defer os.Remove(filepath) // Clean up after sending
-
-		// Upload image to WhatsApp
-		uploaded, err := inst.Client.Upload(context.Background(), mediaData, whatsmeow.MediaImage)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to upload image"})
-			return
-		}
-
-		msg = &waE2E.Message{
-			ImageMessage: &waE2E.ImageMessage{
-				URL:           proto.String(uploaded.URL),
-				DirectPath:    proto.String(uploaded.DirectPath),
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    proto.Uint64(uploaded.FileLength),
-				Caption:       proto.String(req.Caption),
-			},
-		}
-
-	case "audio":
-		filepath = fmt.Sprintf("%s/%s.mp3", mediaDir, filename)
-		if err := os.WriteFile(filepath, mediaData, 0644); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to save audio"})
-			return
-		}
-		defer os.Remove(filepath) // Clean up after sending
-
-		// Upload audio to WhatsApp
-		uploaded, err := inst.Client.Upload(context.Background(), mediaData, whatsmeow.MediaAudio)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to upload audio"})
-			return
-		}
-
-		// Detect mimetype
-		mimeType := http.DetectContentType(mediaData)
-
-		// Check if this is a PTT (voice recording) or regular audio file
-		isPTT := req.IsPTT
-
-		msg = &waE2E.Message{
-			AudioMessage: &waE2E.AudioMessage{
-				URL:           proto.String(uploaded.URL),
-				DirectPath:    proto.String(uploaded.DirectPath),
-				Mimetype:      proto.String(mimeType),
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    proto.Uint64(uploaded.FileLength),
-				PTT:           proto.Bool(isPTT),
-			},
-		}
-
-	case "video":
-		filepath = fmt.Sprintf("%s/%s.mp4", mediaDir, filename)
-		if err := os.WriteFile(filepath, mediaData, 0644); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to save video"})
-			return
-		}
-		defer os.Remove(filepath) // Clean up after sending
-
-		// Upload video to WhatsApp
-		uploaded, err := inst.Client.Upload(context.Background(), mediaData, whatsmeow.MediaVideo)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to upload video"})
-			return
-		}
+	// Add reply context if provided
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
 
-		msg = &waE2E.Message{
-			VideoMessage: &waE2E.VideoMessage{
-				URL:           proto.String(uploaded.URL),
-				DirectPath:    proto.String(uploaded.DirectPath),
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    proto.Uint64(uploaded.FileLength),
-				Caption:       proto.String(req.Caption),
-			},
-		}
+	// Add mentions if provided
+	if len(mentionedJIDs) > 0 {
+		services.EnsureContextInfo(msg).MentionedJID = mentionedJIDs
+	}
 
-	case "file":
-		filepath = fmt.Sprintf("%s/%s", mediaDir, filename)
-		if err := os.WriteFile(filepath, mediaData, 0644); err != nil {
-			c.JSON(500, gin.H{"error": "Failed to save file"})
-			return
-		}
-		defer os.Remove(filepath) // Clean up after sending
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
 
-		// Upload document to WhatsApp
-		uploaded, err := inst.Client.Upload(context.Background(), mediaData, whatsmeow.MediaDocument)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to upload document"})
+	// Only let the recipient open it once if requested
+	if req.ViewOnce {
+		if msg, err = services.WrapViewOnce(msg); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
-
-		msg = &waE2E.Message{
-			DocumentMessage: &waE2E.DocumentMessage{
-				URL:           proto.String(uploaded.URL),
-				DirectPath:    proto.String(uploaded.DirectPath),
-				MediaKey:      uploaded.MediaKey,
-				FileEncSHA256: uploaded.FileEncSHA256,
-				FileSHA256:    uploaded.FileSHA256,
-				FileLength:    proto.Uint64(uploaded.FileLength),
-				FileName:      proto.String(filename),
-			},
-		}
-
-	default:
-		c.JSON(400, gin.H{"error": "Invalid media type"})
-		return
 	}
 
 	// Send message
-	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID, MediaHandle: mediaHandle})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
 
 	c.JSON(200, types.MessageResponse{
 		Status:    "sent",
@@ -803,6 +745,11 @@ func SendContactMessage(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
 	instance.Manager.Mutex.RLock()
 	inst, exists := instance.Manager.Instances[req.InstanceKey]
 	instance.Manager.Mutex.RUnlock()
@@ -815,58 +762,50 @@ func SendContactMessage(c *gin.Context) {
 	inst.Mutex.RLock()
 	if !inst.IsConnected {
 		inst.Mutex.RUnlock()
-		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		instanceNotConnected(c)
 		return
 	}
 	inst.Mutex.RUnlock()
 
-	// Validate and correct phone number
-	validPhone, err := services.ValidateAndCorrectPhone(req.Phone, inst)
-	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
-		return
-	}
-
-	// Parse phone number to JID
-	recipient, err := whatsappTypes.ParseJID(validPhone)
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
 		return
 	}
 
-	// Parse contact phone number to extract the number without @s.whatsapp.net
-	contactPhoneNumber := req.ContactPhone
-	if strings.Contains(contactPhoneNumber, "@s.whatsapp.net") {
-		contactPhoneNumber = strings.Split(contactPhoneNumber, "@")[0]
+	// A single simple contact can still be given with contact_name/contact_phone
+	contacts := req.Contacts
+	if len(contacts) == 0 {
+		if req.ContactName == "" || req.ContactPhone == "" {
+			c.JSON(400, gin.H{"error": "Either contacts or contact_name and contact_phone are required"})
+			return
+		}
+		contacts = []types.Contact{{
+			Name:   req.ContactName,
+			Phones: []types.ContactPhone{{Number: req.ContactPhone}},
+		}}
 	}
 
-	// Create contact message with proper vCard format
-	vcard := fmt.Sprintf("BEGIN:VCARD\nVERSION:3.0\nN:;%s;;;\nFN:%s\nTEL;type=CELL;type=VOICE;waid=%s:+%s\nEND:VCARD",
-		req.ContactName,
-		req.ContactName,
-		contactPhoneNumber,
-		contactPhoneNumber)
-
-	msg := &waE2E.Message{
-		ContactMessage: &waE2E.ContactMessage{
-			DisplayName: proto.String(req.ContactName),
-			Vcard:       proto.String(vcard),
-		},
+	msg, err := services.BuildContactsMessage(contacts)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Add reply context if provided
-	if req.ReplyTo != "" {
-		msg.ContactMessage.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: proto.String(req.ReplyTo),
-		}
-	}
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
 
 	// Send message
-	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
 
 	c.JSON(200, types.MessageResponse{
 		Status:    "sent",
@@ -881,6 +820,11 @@ func SendVoiceMessage(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
 	instance.Manager.Mutex.RLock()
 	inst, exists := instance.Manager.Instances[req.InstanceKey]
 	instance.Manager.Mutex.RUnlock()
@@ -893,102 +837,148 @@ func SendVoiceMessage(c *gin.Context) {
 	inst.Mutex.RLock()
 	if !inst.IsConnected {
 		inst.Mutex.RUnlock()
-		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		instanceNotConnected(c)
 		return
 	}
 	inst.Mutex.RUnlock()
 
-	// Validate and correct phone number
-	validPhone, err := services.ValidateAndCorrectPhone(req.Phone, inst)
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
 		return
 	}
 
-	// Parse phone number to JID
-	recipient, err := whatsappTypes.ParseJID(validPhone)
+	// Download the recording and upload it to WhatsApp, PTT marks it as a voice recording
+	mediaData, err := services.DownloadMedia(req.URL)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		failBeforeSend(c, mediaErrorStatus(err), err)
 		return
 	}
-
-	// Download audio from URL
-	httpResp, err := http.Get(req.URL)
+	msg, mediaHandle, err := services.BuildMediaMessage(inst, recipient, mediaData, "audio", services.MediaOptions{PTT: true})
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to download audio from URL"})
+		failBeforeSend(c, mediaErrorStatus(err), err)
 		return
 	}
-	defer httpResp.Body.Close()
 
-	if httpResp.StatusCode != http.StatusOK {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to download audio: %d", httpResp.StatusCode)})
-		return
+	// Add reply context if provided
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
+
+	// Only let the recipient open it once if requested
+	if req.ViewOnce {
+		if msg, err = services.WrapViewOnce(msg); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 	}
 
-	mediaData, err := io.ReadAll(httpResp.Body)
+	// Send message
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID, MediaHandle: mediaHandle})
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to read audio data"})
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
+
+	c.JSON(200, types.MessageResponse{
+		Status:    "sent",
+		MessageID: resp.ID,
+	})
+}
 
-	// Create media directory if it doesn't exist
-	mediaDir := "./media"
-	if err := os.MkdirAll(mediaDir, 0755); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create media directory"})
+// SendAlbumMessage sends images and videos grouped as an album: a parent
+// album message first, then every item attached to it
+func SendAlbumMessage(c *gin.Context) {
+	var req types.AlbumMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
 		return
 	}
 
-	// Generate unique filename
-	filename := fmt.Sprintf("voice_%d", time.Now().Unix())
-	filepath := fmt.Sprintf("%s/%s.ogg", mediaDir, filename)
-	if err := os.WriteFile(filepath, mediaData, 0644); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to save voice recording"})
+	if err := services.ValidateAlbumItems(req.Items); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	defer os.Remove(filepath) // Clean up after sending
 
-	// Upload voice recording to WhatsApp
-	uploaded, err := inst.Client.Upload(context.Background(), mediaData, whatsmeow.MediaAudio)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to upload voice recording"})
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
 		return
 	}
 
-	// Detect mimetype
-	mimeType := http.DetectContentType(mediaData)
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		instanceNotConnected(c)
+		return
+	}
+	inst.Mutex.RUnlock()
 
-	// Create voice message (PTT = true for voice recordings)
-	msg := &waE2E.Message{
-		AudioMessage: &waE2E.AudioMessage{
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			Mimetype:      proto.String(mimeType),
-			MediaKey:      uploaded.MediaKey,
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uploaded.FileLength),
-			PTT:           proto.Bool(true), // Always true for voice recordings
-		},
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
+		return
+	}
+	if recipient.Server == whatsappTypes.NewsletterServer {
+		c.JSON(400, gin.H{"error": "Albums can't be sent to newsletters"})
+		return
 	}
 
-	// Add reply context if provided
-	if req.ReplyTo != "" {
-		msg.AudioMessage.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: proto.String(req.ReplyTo),
-		}
+	// Upload everything before sending, so a bad URL doesn't leave half an album
+	items, err := services.UploadAlbumItems(inst, recipient, req.Items)
+	if err != nil {
+		failBeforeSend(c, mediaErrorStatus(err), err)
+		return
 	}
 
-	// Send message
-	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+	// Send the parent the items are grouped under
+	album := services.BuildAlbumMessage(req.Items)
+	services.ApplyEphemeralExpiration(album, req.EphemeralExpiration)
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, album, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, album)
 
-	c.JSON(200, types.MessageResponse{
-		Status:    "sent",
-		MessageID: resp.ID,
-	})
+	response := types.AlbumMessageResponse{
+		Status:     "sent",
+		MessageID:  resp.ID,
+		ParentID:   resp.ID,
+		MessageIDs: make([]string, len(items)),
+	}
+	for i, msg := range items {
+		services.AttachToAlbum(msg, recipient, resp.ID)
+		// The quote shows on the first item
+		if i == 0 {
+			services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+		}
+		services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
+
+		itemResp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+		if err != nil {
+			response.Failed = append(response.Failed, types.AlbumItemError{Index: i, Error: err.Error()})
+			continue
+		}
+		services.CacheSentMessage(inst, recipient, itemResp, msg)
+		response.MessageIDs[i] = itemResp.ID
+	}
+	if len(response.Failed) > 0 {
+		response.Status = "partial"
+	}
+
+	c.JSON(200, response)
 }
 
 func SendLocationMessage(c *gin.Context) {
@@ -998,6 +988,11 @@ func SendLocationMessage(c *gin.Context) {
 		return
 	}
 
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
 	instance.Manager.Mutex.RLock()
 	inst, exists := instance.Manager.Instances[req.InstanceKey]
 	instance.Manager.Mutex.RUnlock()
@@ -1010,46 +1005,81 @@ func SendLocationMessage(c *gin.Context) {
 	inst.Mutex.RLock()
 	if !inst.IsConnected {
 		inst.Mutex.RUnlock()
-		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		instanceNotConnected(c)
 		return
 	}
 	inst.Mutex.RUnlock()
 
-	// Validate and correct phone number
-	validPhone, err := services.ValidateAndCorrectPhone(req.Phone, inst)
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
 		return
 	}
 
-	// Parse phone number to JID
-	recipient, err := whatsappTypes.ParseJID(validPhone)
+	// Load the thumbnail shown on the pin, if any
+	thumbnail, err := services.LocationThumbnail(req.Thumbnail, req.ThumbnailURL)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Create location message
-	msg := &waE2E.Message{
-		LocationMessage: &waE2E.LocationMessage{
-			DegreesLatitude:  proto.Float64(req.Latitude),
-			DegreesLongitude: proto.Float64(req.Longitude),
-		},
+	var msg *waE2E.Message
+	var liveDuration time.Duration
+	if req.Live {
+		liveDuration, err = services.LiveLocationDuration(req.LiveDuration)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		msg = services.BuildLiveLocationMessage(&req, thumbnail)
+	} else {
+		msg = &waE2E.Message{
+			LocationMessage: &waE2E.LocationMessage{
+				DegreesLatitude:  proto.Float64(req.Latitude),
+				DegreesLongitude: proto.Float64(req.Longitude),
+				JPEGThumbnail:    thumbnail,
+			},
+		}
+		if req.Name != "" {
+			msg.LocationMessage.Name = proto.String(req.Name)
+		}
+		if req.Address != "" {
+			msg.LocationMessage.Address = proto.String(req.Address)
+		}
+		if req.URL != "" {
+			msg.LocationMessage.URL = proto.String(req.URL)
+		}
+		if req.AccuracyInMeters > 0 {
+			msg.LocationMessage.AccuracyInMeters = proto.Uint32(req.AccuracyInMeters)
+		}
 	}
 
 	// Add reply context if provided
-	if req.ReplyTo != "" {
-		msg.LocationMessage.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: proto.String(req.ReplyTo),
-		}
-	}
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
 
 	// Send message
-	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
+
+	if req.Live {
+		expiresAt := services.StartLiveLocation(inst.ID, resp.ID, recipient, liveDuration)
+		c.JSON(200, types.LiveLocationResponse{
+			Status:         "sent",
+			MessageID:      resp.ID,
+			SequenceNumber: 1,
+			ExpiresAt:      &expiresAt,
+		})
+		return
+	}
 
 	c.JSON(200, types.MessageResponse{
 		Status:    "sent",
@@ -1057,8 +1087,8 @@ func SendLocationMessage(c *gin.Context) {
 	})
 }
 
-func SendInteractiveMessage(c *gin.Context) {
-	var req types.InteractiveMessageRequest
+func UpdateLiveLocation(c *gin.Context) {
+	var req types.LiveLocationUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request body"})
 		return
@@ -1081,68 +1111,1936 @@ func SendInteractiveMessage(c *gin.Context) {
 	}
 	inst.Mutex.RUnlock()
 
-	// Validate and correct phone number
-	validPhone, err := services.ValidateAndCorrectPhone(req.Phone, inst)
+	// Build the update with the next sequence number
+	chat, msg, sequence, err := services.NextLiveLocationUpdate(inst.ID, &req)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Parse phone number to JID
-	recipient, err := whatsappTypes.ParseJID(validPhone)
+	// Send message
+	resp, err := inst.Client.SendMessage(context.Background(), chat, msg)
 	if err != nil {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Validate buttons (max 3 buttons allowed)
-	if len(req.Buttons) > 3 {
-		c.JSON(400, gin.H{"error": "Maximum 3 buttons allowed"})
+	c.JSON(200, types.LiveLocationResponse{
+		Status:         "updated",
+		MessageID:      req.MessageID,
+		UpdateID:       resp.ID,
+		SequenceNumber: sequence,
+	})
+}
+
+func SendInteractiveMessage(c *gin.Context) {
+	var req types.InteractiveMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	if len(req.Buttons) == 0 {
-		c.JSON(400, gin.H{"error": "At least one button is required"})
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
 		return
 	}
 
-	// For now, send a simple text message with button information
-	// TODO: Implement proper interactive message when protobuf structure is confirmed
-	buttonText := "Available options:\n"
-	for i, button := range req.Buttons {
-		buttonText += fmt.Sprintf("%d. %s\n", i+1, button.Title)
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		instanceNotConnected(c)
+		return
 	}
+	inst.Mutex.RUnlock()
 
-	messageText := fmt.Sprintf("**%s**\n\n%s\n\n%s\n\n%s", req.Title, req.Body, buttonText, req.Footer)
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
+		return
+	}
 
-	// Create text message with button information
-	msg := &waE2E.Message{
-		ExtendedTextMessage: &waE2E.ExtendedTextMessage{
-			Text: proto.String(messageText),
-		},
+	// Build the buttons, list or native flow message
+	msg, err := services.BuildInteractiveMessage(&req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Add reply context if provided
-	if req.ReplyTo != "" {
-		msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-			StanzaID: proto.String(req.ReplyTo),
-		}
-	}
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
 
 	// Send message
-	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg)
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
 
 	c.JSON(200, types.MessageResponse{
 		Status:    "sent",
 		MessageID: resp.ID,
-		Error:     "Note: Interactive buttons not yet implemented. Sent as formatted text message.",
 	})
 }
 
+func SendPollMessage(c *gin.Context) {
+	var req types.PollMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if err := services.ValidateEphemeralExpiration(req.EphemeralExpiration); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		instanceNotConnected(c)
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	// Validate options (WhatsApp allows 2 to 12 distinct options)
+	if len(req.Options) < 2 || len(req.Options) > 12 {
+		c.JSON(400, gin.H{"error": "A poll needs between 2 and 12 options"})
+		return
+	}
+	seen := make(map[string]bool, len(req.Options))
+	for _, option := range req.Options {
+		if option == "" || seen[option] {
+			c.JSON(400, gin.H{"error": "Poll options must be non-empty and unique"})
+			return
+		}
+		seen[option] = true
+	}
+	if req.SelectableCount < 0 || req.SelectableCount > len(req.Options) {
+		c.JSON(400, gin.H{"error": "selectable_count must be between 0 and the number of options"})
+		return
+	}
+
+	// Resolve the recipient, a phone number or any other JID
+	recipient, err := services.ResolveChatJID(services.Recipient(req.To, req.Phone), inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid recipient: %v", err)})
+		return
+	}
+
+	// Create poll message
+	msg := inst.Client.BuildPollCreation(req.Question, req.Options, req.SelectableCount)
+
+	// Add reply context if provided
+	services.ApplyReplyContext(inst, recipient, msg, req.ReplyTo)
+
+	// Make the message disappear if requested
+	services.ApplyEphemeralExpiration(msg, req.EphemeralExpiration)
+
+	// Send message
+	resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	services.CacheSentMessage(inst, recipient, resp, msg)
+
+	c.JSON(200, types.MessageResponse{
+		Status:    "sent",
+		MessageID: resp.ID,
+	})
+}
+
+func GetPollResults(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+	messageID := c.Param("messageId")
+
+	instance.Manager.Mutex.RLock()
+	_, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	tally, ok := services.GetPollTally(instanceKey, messageID)
+	if !ok {
+		c.JSON(404, gin.H{"error": "Poll not found"})
+		return
+	}
+
+	c.JSON(200, tally)
+}
+
+// GetMessageStatus returns the delivery status of a message the instance sent
+func GetMessageStatus(c *gin.Context) {
+	status, err := services.GetMessageStatus(c.Param("instanceKey"), c.Param("messageId"))
+	if errors.Is(err, services.ErrMessageStatusNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, status)
+}
+
+// ListChatMessageStatuses returns the delivery status of the recent messages
+// the instance sent to a chat
+func ListChatMessageStatuses(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > services.MaxMessageStatusLimit {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", services.MaxMessageStatusLimit)})
+			return
+		}
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	// Statuses are stored under the chat messages were sent to, which for
+	// LIDs is the phone number when it's known
+	chat, err := utils.ParseJIDWithLIDSupport(c.Param("chat"), inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	statuses, err := services.ListChatMessageStatuses(instanceKey, chat, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"instance_key": instanceKey,
+		"chat":         chat.String(),
+		"messages":     statuses,
+		"count":        len(statuses),
+	})
+}
+
+func SendTextStatus(c *gin.Context) {
+	var req types.TextStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Audience) > 0 {
+		c.JSON(501, gin.H{"error": services.ErrStatusAudienceUnsupported.Error()})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		instanceNotConnected(c)
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	msg, err := services.BuildTextStatus(&req)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Post the status
+	resp, err := inst.Client.SendMessage(context.Background(), whatsappTypes.StatusBroadcastJID, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	services.CacheSentMessage(inst, whatsappTypes.StatusBroadcastJID, resp, msg)
+
+	c.JSON(200, types.MessageResponse{
+		Status:    "sent",
+		MessageID: resp.ID,
+	})
+}
+
+func SendMediaStatus(c *gin.Context) {
+	var req types.MediaStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Audience) > 0 {
+		c.JSON(501, gin.H{"error": services.ErrStatusAudienceUnsupported.Error()})
+		return
+	}
+	if req.Type != "image" && req.Type != "video" {
+		c.JSON(400, gin.H{"error": "Status type must be image or video"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		instanceNotConnected(c)
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	// Download the media and upload it to WhatsApp
+	mediaData, err := services.DownloadMedia(req.URL)
+	if err != nil {
+		failBeforeSend(c, mediaErrorStatus(err), err)
+		return
+	}
+	msg, _, err := services.BuildMediaMessage(inst, whatsappTypes.StatusBroadcastJID, mediaData, req.Type, services.MediaOptions{Caption: req.Caption})
+	if err != nil {
+		failBeforeSend(c, mediaErrorStatus(err), err)
+		return
+	}
+
+	// Post the status
+	resp, err := inst.Client.SendMessage(context.Background(), whatsappTypes.StatusBroadcastJID, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	services.CacheSentMessage(inst, whatsappTypes.StatusBroadcastJID, resp, msg)
+
+	c.JSON(200, types.MessageResponse{
+		Status:    "sent",
+		MessageID: resp.ID,
+	})
+}
+
+// GetStatusPrivacy returns who the account's status posts are sent to
+func GetStatusPrivacy(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	settings, err := inst.Client.GetStatusPrivacy()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	privacy := make([]types.StatusPrivacy, 0, len(settings))
+	for _, setting := range settings {
+		list := make([]string, 0, len(setting.List))
+		for _, jid := range setting.List {
+			list = append(list, jid.User)
+		}
+		privacy = append(privacy, types.StatusPrivacy{
+			Type:      string(setting.Type),
+			List:      list,
+			IsDefault: setting.IsDefault,
+		})
+	}
+
+	c.JSON(200, gin.H{
+		"instance_key": instanceKey,
+		"privacy":      privacy,
+	})
+}
+
+// maxForwardTargets caps how many chats one forward request can go to
+const maxForwardTargets = 50
+
+func ForwardMessage(c *gin.Context) {
+	var req types.ForwardMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.To) == 0 || len(req.To) > maxForwardTargets {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("to must list 1 to %d chats", maxForwardTargets)})
+		return
+	}
+	if req.CustomMessageID != "" && len(req.To) > 1 {
+		c.JSON(400, gin.H{"error": "custom_message_id can only be used when forwarding to a single chat"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		instanceNotConnected(c)
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	source, err := services.ResolveChatJID(req.Chat, inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid chat: %v", err)})
+		return
+	}
+
+	// The original content comes from the recent message cache
+	original, ok := services.GetCachedMessage(inst.ID, req.MessageID)
+	if !ok || (original.Info.Chat.Server != whatsappTypes.HiddenUserServer && original.Info.Chat.ToNonAD() != source.ToNonAD()) {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("Message %s %s", req.MessageID, services.ErrForwardSourceNotFound)})
+		return
+	}
+
+	response := types.ForwardMessageResponse{Results: make([]types.ForwardResult, 0, len(req.To))}
+	sent := 0
+	for _, to := range req.To {
+		result := types.ForwardResult{To: to, Status: "failed"}
+
+		recipient, err := services.ResolveChatJID(to, inst)
+		if err != nil {
+			result.Error = fmt.Sprintf("Invalid phone number format: %v", err)
+			response.Results = append(response.Results, result)
+			continue
+		}
+
+		// Each target gets its own copy, polls even get their own secret
+		msg, err := services.BuildForwardMessage(inst, original.Message)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		resp, err := inst.Client.SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: req.CustomMessageID})
+		if err != nil {
+			result.Error = err.Error()
+			response.Results = append(response.Results, result)
+			continue
+		}
+		services.CacheSentMessage(inst, recipient, resp, msg)
+
+		result.Status = "sent"
+		result.MessageID = resp.ID
+		response.Results = append(response.Results, result)
+		sent++
+	}
+
+	switch sent {
+	case len(req.To):
+		response.Status = "sent"
+	case 0:
+		response.Status = "failed"
+		c.JSON(500, response)
+		return
+	default:
+		response.Status = "partial"
+	}
+
+	c.JSON(200, response)
+}
+
+// revokeWindow is how long after sending WhatsApp still accepts a revoke
+const revokeWindow = 60 * time.Hour
+
+func RevokeMessage(c *gin.Context) {
+	var req types.RevokeMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	// Resolve the chat the messages belong to
+	chat, err := services.ResolveChatJID(req.Phone, inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		return
+	}
+
+	// Group admin status is only fetched when an admin revoke is requested
+	var isAdmin *bool
+
+	results := make([]types.RevokeResult, 0, len(req.Messages))
+	revoked := 0
+	for _, target := range req.Messages {
+		result := types.RevokeResult{MessageID: target.MessageID}
+
+		// Recent messages are in the cache when the client didn't give the time
+		var sentAt time.Time
+		if target.Timestamp > 0 {
+			sentAt = time.Unix(target.Timestamp, 0)
+		} else if cached, ok := services.GetCachedMessage(inst.ID, target.MessageID); ok {
+			sentAt = cached.Info.Timestamp
+		}
+		if !sentAt.IsZero() && time.Since(sentAt) > revokeWindow {
+			result.Status = "error"
+			result.Error = fmt.Sprintf("Message is older than the revoke window of %s", revokeWindow)
+			results = append(results, result)
+			continue
+		}
+
+		sender := whatsappTypes.EmptyJID
+		if target.Participant != "" {
+			sender, err = utils.ParseJIDWithLIDSupport(target.Participant, inst)
+			if err != nil {
+				result.Status = "error"
+				result.Error = fmt.Sprintf("Invalid participant: %v", err)
+				results = append(results, result)
+				continue
+			}
+		}
+
+		// Revoking someone else's message is only possible as a group admin
+		if !sender.IsEmpty() && !isOwnJID(inst, sender) {
+			if chat.Server != whatsappTypes.GroupServer {
+				result.Status = "error"
+				result.Error = "Messages from other participants can only be revoked in groups"
+				results = append(results, result)
+				continue
+			}
+			if isAdmin == nil {
+				admin := isGroupAdmin(inst, chat)
+				isAdmin = &admin
+			}
+			if !*isAdmin {
+				result.Status = "error"
+				result.Error = "Instance must be a group admin to revoke messages from other participants"
+				results = append(results, result)
+				continue
+			}
+		}
+
+		resp, err := inst.Client.SendMessage(context.Background(), chat, inst.Client.BuildRevoke(chat, sender, target.MessageID))
+		if err != nil {
+			result.Status = "error"
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		result.Status = "revoked"
+		result.RevokeID = resp.ID
+		results = append(results, result)
+		revoked++
+	}
+
+	status := "revoked"
+	if revoked == 0 {
+		status = "failed"
+	} else if revoked < len(req.Messages) {
+		status = "partial"
+	}
+
+	c.JSON(200, types.RevokeMessageResponse{
+		Status:  status,
+		Results: results,
+	})
+}
+
+// isOwnJID checks whether the JID belongs to the instance's own account
+func isOwnJID(inst *types.Instance, jid whatsappTypes.JID) bool {
+	if inst.Client.Store.ID != nil && jid.User == inst.Client.Store.ID.User {
+		return true
+	}
+	return !inst.Client.Store.LID.IsEmpty() && jid.User == inst.Client.Store.LID.User
+}
+
+// isGroupAdmin checks whether the instance's account is an admin of the group
+func isGroupAdmin(inst *types.Instance, group whatsappTypes.JID) bool {
+	info, err := inst.Client.GetGroupInfo(group)
+	if err != nil {
+		log.Printf("Error getting group info for %s: %v", group.String(), err)
+		return false
+	}
+	for _, participant := range info.Participants {
+		if (isOwnJID(inst, participant.JID) || isOwnJID(inst, participant.LID)) && (participant.IsAdmin || participant.IsSuperAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+func MarkMessagesRead(c *gin.Context) {
+	var req types.MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	chat, err := services.ResolveChatJID(req.Phone, inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		return
+	}
+
+	var marked []string
+	if len(req.MessageIDs) > 0 {
+		sender := whatsappTypes.EmptyJID
+		if req.Sender != "" {
+			sender, err = utils.ParseJIDWithLIDSupport(req.Sender, inst)
+			if err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid sender: %v", err)})
+				return
+			}
+		} else if chat.Server != whatsappTypes.GroupServer {
+			// In direct chats the other side is always the sender
+			sender = chat
+		}
+		marked, err = services.MarkMessagesRead(inst, chat, req.MessageIDs, sender)
+	} else {
+		upTo := time.Now()
+		if req.UpTo > 0 {
+			upTo = time.Unix(req.UpTo, 0)
+		}
+		marked, err = services.MarkChatRead(inst, chat, upTo)
+	}
+
+	if marked == nil {
+		marked = []string{}
+	}
+	if err != nil {
+		c.JSON(500, types.MarkReadResponse{
+			Status:     "error",
+			Chat:       chat.String(),
+			MessageIDs: marked,
+			Count:      len(marked),
+			Error:      err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, types.MarkReadResponse{
+		Status:     "read",
+		Chat:       chat.String(),
+		MessageIDs: marked,
+		Count:      len(marked),
+	})
+}
+
+func SetAutoRead(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	var req types.AutoReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.Lock()
+	inst.AutoRead = req.Enabled
+	inst.Mutex.Unlock()
+
+	c.JSON(200, gin.H{
+		"status":       "updated",
+		"instance_key": instanceKey,
+		"auto_read":    req.Enabled,
+	})
+}
+
+func GetWebhooks(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	c.JSON(200, services.GetWebhookConfig(inst))
+}
+
+func SetWebhooks(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	var req types.WebhookConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	if err := services.ValidateWebhookDestinations(req.Webhooks); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, services.SetWebhookDestinations(inst, req.Webhooks))
+}
+
+func ResetWebhooks(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	c.JSON(200, services.SetWebhookDestinations(inst, nil))
+}
+
+func SetChatPresence(c *gin.Context) {
+	var req types.ChatPresenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	chat, err := services.ResolveChatJID(req.Phone, inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		return
+	}
+
+	if req.State != "composing" && req.State != "recording" && req.State != "paused" {
+		c.JSON(400, gin.H{"error": "State must be one of: composing, recording, paused"})
+		return
+	}
+
+	if err := services.SetChatPresence(inst, chat, req.State); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status": "sent",
+		"chat":   chat.String(),
+		"state":  req.State,
+	})
+}
+
+func SetDisappearingTimer(c *gin.Context) {
+	var req types.DisappearingTimerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	timer, err := services.ParseDisappearingTimer(req.Timer)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	chat, err := services.ResolveChatJID(req.Phone, inst)
+	if err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid phone number format: %v", err)})
+		return
+	}
+
+	if err := inst.Client.SetDisappearingTimer(chat, timer); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":               "updated",
+		"chat":                 chat.String(),
+		"ephemeral_expiration": int(timer.Seconds()),
+	})
+}
+
+func SetDefaultDisappearingTimer(c *gin.Context) {
+	var req types.DefaultDisappearingTimerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	timer, err := services.ParseDisappearingTimer(req.Timer)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	instanceKey := c.Param("instanceKey")
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	if err := inst.Client.SetDefaultDisappearingTimer(timer); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":               "updated",
+		"instance_key":         instanceKey,
+		"ephemeral_expiration": int(timer.Seconds()),
+	})
+}
+
+func SetPresence(c *gin.Context) {
+	var req types.PresenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	inst.Mutex.RLock()
+	if !inst.IsConnected {
+		inst.Mutex.RUnlock()
+		c.JSON(400, gin.H{"error": "Instance is not connected"})
+		return
+	}
+	inst.Mutex.RUnlock()
+
+	if req.State != "available" && req.State != "unavailable" {
+		c.JSON(400, gin.H{"error": "State must be one of: available, unavailable"})
+		return
+	}
+
+	if err := services.SetGlobalPresence(inst, req.State); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status": "sent",
+		"state":  req.State,
+	})
+}
+
+// idempotencyKeyField is the body field alternative to the Idempotency-Key header
+const idempotencyKeyField = "idempotency_key"
+
+// responseCapture keeps a copy of everything written to the response
+type responseCapture struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseCapture) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCapture) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// IdempotentSend runs first on the send endpoints. A request with an
+// Idempotency-Key header (or idempotency_key field) is only processed once
+// per instance: repeats get the stored response of the first request, and
+// reusing the key for a different body is rejected. Failed requests don't
+// keep the key, so they can be retried.
+func IdempotentSend(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		c.Next()
+		return
+	}
+
+	key := c.GetHeader("Idempotency-Key")
+	if raw, ok := fields[idempotencyKeyField]; ok {
+		var fieldKey string
+		if err := json.Unmarshal(raw, &fieldKey); err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "idempotency_key must be a string"})
+			return
+		}
+		if key != "" && fieldKey != "" && key != fieldKey {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Idempotency-Key header and idempotency_key field differ"})
+			return
+		}
+		if key == "" {
+			key = fieldKey
+		}
+		delete(fields, idempotencyKeyField)
+
+		// The key isn't part of the message, don't pass it on
+		if body, err = json.Marshal(fields); err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if key == "" {
+		c.Next()
+		return
+	}
+	if err := services.ValidateIdempotencyKey(key); err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req struct {
+		InstanceKey string `json:"instance_key"`
+	}
+	json.Unmarshal(body, &req)
+
+	// The endpoint is part of the request, the same body sent to another endpoint is a different request
+	fields["_endpoint"], _ = json.Marshal(c.FullPath())
+	requestHash, err := services.IdempotencyRequestHash(fields)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	record, err := services.ReserveIdempotencyKey(req.InstanceKey, key, requestHash)
+	switch {
+	case errors.Is(err, services.ErrIdempotencyKeyMismatch):
+		c.AbortWithStatusJSON(422, gin.H{"error": "Idempotency-Key was already used with a different request"})
+		return
+	case errors.Is(err, services.ErrIdempotencyKeyInProgress):
+		c.AbortWithStatusJSON(409, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	case record != nil:
+		// Already processed, replay the stored response
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(record.ResponseCode, "application/json; charset=utf-8", record.Response)
+		c.Abort()
+		return
+	}
+
+	capture := &responseCapture{ResponseWriter: c.Writer}
+	c.Writer = capture
+	c.Next()
+
+	// Invalid requests and failures before the send can be retried with the
+	// same key. Any other failure may have sent the message anyway, so it's
+	// kept like a success and a retry replays it instead of sending again.
+	status := c.Writer.Status()
+	if (status >= 400 && status < 500) || (status >= 500 && services.SendNotAttempted(capture.body.Bytes())) {
+		services.ReleaseIdempotencyKey(req.InstanceKey, key)
+	} else {
+		services.CompleteIdempotencyKey(req.InstanceKey, key, status, capture.body.Bytes())
+	}
+}
+
+// ScheduleMessage runs before the send handlers. Requests with a future
+// send_at or a recurrence are stored and replayed by the scheduler instead of
+// being sent now; everything else goes straight to the send handler.
+func ScheduleMessage(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// Let the send handler report the invalid body
+		c.Next()
+		return
+	}
+	_, hasSendAt := fields["send_at"]
+	_, hasRecurrence := fields["recurrence"]
+	if !hasSendAt && !hasRecurrence {
+		c.Next()
+		return
+	}
+
+	var req types.ScheduleRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": fmt.Sprintf("Invalid schedule: %v", err)})
+		return
+	}
+	if _, ok := fields["custom_message_id"]; ok && req.Recurrence != "" {
+		// Every run is a new message, they can't share an ID
+		c.AbortWithStatusJSON(400, gin.H{"error": "custom_message_id can't be used with a recurrence"})
+		return
+	}
+
+	services.StripScheduleFields(fields)
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// A one-off send_at that already passed is just sent now
+	if req.Recurrence == "" && (req.SendAt == nil || !req.SendAt.After(time.Now())) {
+		c.Request.Body = io.NopCloser(bytes.NewReader(payload))
+		c.Next()
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	_, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.AbortWithStatusJSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	start := time.Now()
+	if req.SendAt != nil {
+		start = *req.SendAt
+	}
+	sendAt, err := services.FirstScheduledRun(start, req.Recurrence, req.Timezone)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	scheduled := &types.ScheduledMessage{
+		InstanceKey: req.InstanceKey,
+		Endpoint:    c.FullPath(),
+		Payload:     payload,
+		SendAt:      sendAt,
+		StartsAt:    start,
+		Recurrence:  req.Recurrence,
+		Timezone:    req.Timezone,
+	}
+	if err := services.ScheduleMessage(scheduled); err != nil {
+		c.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.AbortWithStatusJSON(200, types.ScheduleResponse{
+		Status:     "scheduled",
+		ScheduleID: scheduled.ID,
+		SendAt:     scheduled.SendAt,
+		Recurrence: scheduled.Recurrence,
+	})
+}
+
+// QueueMessage runs before the send handlers and puts the send request in the
+// instance's outbound queue, which paces sends and keeps them while the
+// instance is offline. By default the request waits for the queued send and
+// returns its response; with "async": true it returns the queue ID right away.
+func QueueMessage(c *gin.Context) {
+	// Requests replayed by the queue worker are the actual sends
+	if services.IsQueueReplay(c.Request.Context()) {
+		c.Next()
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	var req types.QueueRequest
+	if json.Unmarshal(body, &fields) != nil || json.Unmarshal(body, &req) != nil {
+		// Let the send handler report the invalid body
+		c.Next()
+		return
+	}
+
+	priority, err := services.QueuePriority(req.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	_, exists := instance.Manager.Instances[req.InstanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.AbortWithStatusJSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	services.StripQueueFields(fields)
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	queued := &types.QueuedMessage{
+		InstanceKey: req.InstanceKey,
+		Endpoint:    c.FullPath(),
+		Payload:     payload,
+		Priority:    priority,
+	}
+	if err := services.EnqueueMessage(queued); err != nil {
+		// Without the queue database, send directly like before the queue existed
+		log.Printf("Warning: Outbound queue unavailable, sending directly: %v", err)
+		c.Request.Body = io.NopCloser(bytes.NewReader(payload))
+		c.Next()
+		return
+	}
+
+	queuedResponse := types.QueueResponse{
+		Status:   "queued",
+		QueueID:  queued.ID,
+		Priority: queued.Priority,
+	}
+	// 202 tells a message that is still queued apart from one that was sent
+	if req.Async {
+		c.AbortWithStatusJSON(202, queuedResponse)
+		return
+	}
+
+	code, result, ok := services.AwaitQueuedMessage(c.Request.Context(), req.InstanceKey, queued.ID)
+	if !ok {
+		// Still waiting for its turn (or for the instance to reconnect), the caller can poll it
+		c.AbortWithStatusJSON(202, queuedResponse)
+		return
+	}
+	c.Header("X-Queue-ID", queued.ID)
+	c.Data(code, "application/json; charset=utf-8", result)
+	c.Abort()
+}
+
+// CheckMessageMetadata runs before a send is scheduled or queued, so an
+// invalid metadata object or a custom_message_id that was already used comes
+// back to the caller right away instead of failing the later replay.
+func CheckMessageMetadata(c *gin.Context) {
+	req, ok := bindMessageMetadata(c)
+	if !ok {
+		return
+	}
+
+	if req.Metadata != nil {
+		if err := services.ValidateMessageMetadata(req.Metadata); err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.CustomMessageID != "" {
+		if err := services.ValidateCustomMessageID(req.InstanceKey, req.CustomMessageID); err != nil {
+			c.AbortWithStatusJSON(metadataErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.Next()
+}
+
+// TrackMessageMetadata runs right before the send handlers, when the message
+// is actually sent rather than scheduled or queued. It stores the request's
+// metadata against the IDs of the sent messages so later webhooks about them
+// carry it.
+func TrackMessageMetadata(c *gin.Context) {
+	req, ok := bindMessageMetadata(c)
+	if !ok {
+		return
+	}
+	if req.Metadata == nil {
+		c.Next()
+		return
+	}
+
+	capture := &responseCapture{ResponseWriter: c.Writer}
+	c.Writer = capture
+	c.Next()
+
+	if status := c.Writer.Status(); status >= 200 && status < 300 {
+		services.RecordMessageMetadata(req.InstanceKey, sentMessageIDs(capture.body.Bytes()), req.Metadata)
+	}
+}
+
+// bindMessageMetadata reads the metadata fields of a send request. Bodies that
+// aren't JSON are passed on for the send handler to report.
+func bindMessageMetadata(c *gin.Context) (*types.MessageMetadataRequest, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req types.MessageMetadataRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.Next()
+		return nil, false
+	}
+	if string(req.Metadata) == "null" {
+		req.Metadata = nil
+	}
+	return &req, true
+}
+
+// sentMessageIDs returns the IDs of the messages in a send endpoint's response
+func sentMessageIDs(body []byte) []string {
+	var resp struct {
+		MessageID  string   `json:"message_id"`
+		MessageIDs []string `json:"message_ids"`
+		Results    []struct {
+			MessageID string `json:"message_id"`
+		} `json:"results"`
+	}
+	json.Unmarshal(body, &resp)
+
+	var ids []string
+	if resp.MessageID != "" {
+		ids = append(ids, resp.MessageID)
+	}
+	for _, id := range resp.MessageIDs {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	for _, result := range resp.Results {
+		if result.MessageID != "" {
+			ids = append(ids, result.MessageID)
+		}
+	}
+	return ids
+}
+
+func metadataErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidMetadata):
+		return 400
+	case errors.Is(err, services.ErrMessageIDInUse):
+		return 409
+	default:
+		return 500
+	}
+}
+
+func ListQueuedMessages(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	messages, err := services.ListQueuedMessages(instanceKey, c.Query("status"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"instance_key": instanceKey,
+		"queue":        messages,
+		"count":        len(messages),
+	})
+}
+
+func GetQueuedMessage(c *gin.Context) {
+	msg, err := services.GetQueuedMessage(c.Param("instanceKey"), c.Param("queueId"))
+	if err != nil {
+		c.JSON(queuedMessageErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, msg)
+}
+
+func CancelQueuedMessage(c *gin.Context) {
+	msg, err := services.CancelQueuedMessage(c.Param("instanceKey"), c.Param("queueId"))
+	if err != nil {
+		c.JSON(queuedMessageErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, msg)
+}
+
+func GetQueueSettings(c *gin.Context) {
+	settings, err := services.GetQueueSettings(c.Param("instanceKey"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, settings)
+}
+
+func UpdateQueueSettings(c *gin.Context) {
+	var req types.QueueSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instanceKey := c.Param("instanceKey")
+
+	instance.Manager.Mutex.RLock()
+	_, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		c.JSON(404, gin.H{"error": "Instance not found"})
+		return
+	}
+
+	if (req.RatePerMinute != nil && *req.RatePerMinute < 0) || (req.JitterMs != nil && *req.JitterMs < 0) || (req.DailyCap != nil && *req.DailyCap < 0) {
+		c.JSON(400, gin.H{"error": "Queue settings can't be negative"})
+		return
+	}
+
+	settings, err := services.UpdateQueueSettings(instanceKey, &req)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, settings)
+}
+
+// failBeforeSend responds to a send that failed before anything was handed to
+// WhatsApp, like a media download or upload. "sent": false tells the queue
+// and idempotency keys that the request is safe to repeat.
+func failBeforeSend(c *gin.Context, code int, err error) {
+	c.JSON(code, gin.H{"error": err.Error(), "sent": false})
+}
+
+// instanceNotConnected responds to a send for a disconnected instance. The
+// dedicated status tells the queue, scheduler and campaigns to wait for the
+// instance instead of failing the message.
+func instanceNotConnected(c *gin.Context) {
+	c.JSON(503, gin.H{"error": "Instance is not connected", "sent": false})
+}
+
+// mediaErrorStatus maps media download and upload errors to HTTP status codes
+func mediaErrorStatus(err error) int {
+	if errors.Is(err, services.ErrMediaTooLarge) {
+		return 413
+	}
+	return 500
+}
+
+// queuedMessageErrorStatus maps queued message errors to HTTP status codes
+func queuedMessageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrQueuedMessageNotFound):
+		return 404
+	case errors.Is(err, services.ErrQueuedMessageNotPending):
+		return 409
+	default:
+		return 500
+	}
+}
+
+func ListScheduledMessages(c *gin.Context) {
+	instanceKey := c.Param("instanceKey")
+
+	messages, err := services.ListScheduledMessages(instanceKey, c.Query("status"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"instance_key": instanceKey,
+		"scheduled":    messages,
+		"count":        len(messages),
+	})
+}
+
+func GetScheduledMessage(c *gin.Context) {
+	msg, err := services.GetScheduledMessage(c.Param("instanceKey"), c.Param("scheduleId"))
+	if err != nil {
+		c.JSON(scheduledMessageErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, msg)
+}
+
+func UpdateScheduledMessage(c *gin.Context) {
+	var req types.UpdateScheduledMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	msg, err := services.UpdateScheduledMessage(c.Param("instanceKey"), c.Param("scheduleId"), &req)
+	if err != nil {
+		c.JSON(scheduledMessageErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, msg)
+}
+
+func CancelScheduledMessage(c *gin.Context) {
+	msg, err := services.CancelScheduledMessage(c.Param("instanceKey"), c.Param("scheduleId"))
+	if err != nil {
+		c.JSON(scheduledMessageErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, msg)
+}
+
+// scheduledMessageErrorStatus maps scheduled message errors to HTTP status codes
+func scheduledMessageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrScheduledMessageNotFound):
+		return 404
+	case errors.Is(err, services.ErrScheduledMessageNotPending):
+		return 409
+	case errors.Is(err, services.ErrInvalidSchedule):
+		return 400
+	default:
+		return 500
+	}
+}
+
+// CreateCampaign accepts a JSON campaign, or a multipart form with the
+// campaign JSON in the "campaign" field and a "recipients" CSV file
+func CreateCampaign(c *gin.Context) {
+	var req types.CampaignRequest
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if err := json.Unmarshal([]byte(c.PostForm("campaign")), &req); err != nil || req.Name == "" {
+			c.JSON(400, gin.H{"error": "Invalid campaign field"})
+			return
+		}
+		if file, err := c.FormFile("recipients"); err == nil {
+			f, err := file.Open()
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Failed to read recipients file"})
+				return
+			}
+			recipients, err := services.ParseRecipientsCSV(f)
+			f.Close()
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+			req.Recipients = append(req.Recipients, recipients...)
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	instance.Manager.Mutex.RLock()
+	for _, instanceKey := range append([]string{req.InstanceKey}, req.InstanceKeys...) {
+		if _, exists := instance.Manager.Instances[instanceKey]; instanceKey != "" && !exists {
+			instance.Manager.Mutex.RUnlock()
+			c.JSON(404, gin.H{"error": fmt.Sprintf("Instance %s not found", instanceKey)})
+			return
+		}
+	}
+	instance.Manager.Mutex.RUnlock()
+
+	campaign, err := services.CreateCampaign(&req)
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, campaign)
+}
+
+func ListCampaigns(c *gin.Context) {
+	campaigns, err := services.ListCampaigns(c.Query("status"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"campaigns": campaigns,
+		"count":     len(campaigns),
+	})
+}
+
+func GetCampaign(c *gin.Context) {
+	campaign, err := services.GetCampaign(c.Param("campaignId"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, campaign)
+}
+
+func PauseCampaign(c *gin.Context) {
+	campaign, err := services.PauseCampaign(c.Param("campaignId"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, campaign)
+}
+
+func ResumeCampaign(c *gin.Context) {
+	campaign, err := services.ResumeCampaign(c.Param("campaignId"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, campaign)
+}
+
+func CancelCampaign(c *gin.Context) {
+	campaign, err := services.CancelCampaign(c.Param("campaignId"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, campaign)
+}
+
+func GetCampaignRecipients(c *gin.Context) {
+	campaignID := c.Param("campaignId")
+
+	recipients, err := services.GetCampaignRecipients(campaignID, c.Query("status"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"campaign_id": campaignID,
+		"recipients":  recipients,
+		"count":       len(recipients),
+	})
+}
+
+// ExportCampaignReport returns the per-recipient report as a CSV download or JSON
+func ExportCampaignReport(c *gin.Context) {
+	campaign, err := services.GetCampaign(c.Param("campaignId"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	recipients, err := services.GetCampaignRecipients(campaign.ID, c.Query("status"))
+	if err != nil {
+		c.JSON(campaignErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	switch c.DefaultQuery("format", "csv") {
+	case "csv":
+		var buf bytes.Buffer
+		if err := services.WriteCampaignReportCSV(&buf, recipients); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="campaign-%s.csv"`, campaign.ID))
+		c.Data(200, "text/csv; charset=utf-8", buf.Bytes())
+	case "json":
+		c.JSON(200, gin.H{
+			"campaign":   campaign,
+			"recipients": recipients,
+		})
+	default:
+		c.JSON(400, gin.H{"error": "format must be csv or json"})
+	}
+}
+
+// campaignErrorStatus maps campaign errors to HTTP status codes
+func campaignErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrCampaignNotFound):
+		return 404
+	case errors.Is(err, services.ErrCampaignState):
+		return 409
+	case errors.Is(err, services.ErrInvalidCampaign):
+		return 400
+	default:
+		return 500
+	}
+}
+
+// templateSendHandlers are the send handlers rendered templates go through
+var templateSendHandlers = map[string]gin.HandlerFunc{
+	"text":     SendTextMessage,
+	"media":    SendMediaMessage,
+	"contact":  SendContactMessage,
+	"location": SendLocationMessage,
+}
+
+// PrepareTemplateMessage runs before a template send is scheduled or
+// queued. It checks the template renders with the given variables, so errors
+// come back right away, and pins the template version so a later replay
+// sends the same message.
+func PrepareTemplateMessage(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]json.RawMessage
+	var req types.TemplateMessageRequest
+	if json.Unmarshal(body, &fields) != nil || json.Unmarshal(body, &req) != nil || req.Template == "" {
+		// Let the send handler report the invalid body
+		c.Next()
+		return
+	}
+
+	template, _, err := services.RenderMessageTemplate(req.Template, req.Version, templateMessageVariables(&req))
+	if err != nil {
+		c.AbortWithStatusJSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	fields["version"], _ = json.Marshal(template.Version)
+	if body, err = json.Marshal(fields); err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Header("X-Template-Version", strconv.Itoa(template.Version))
+	c.Next()
+}
+
+// SendTemplateMessage renders a template and sends it with the send handler for its type
+func SendTemplateMessage(c *gin.Context) {
+	var req types.TemplateMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	template, rendered, err := services.RenderMessageTemplate(req.Template, req.Version, templateMessageVariables(&req))
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	var message map[string]interface{}
+	if err := json.Unmarshal(rendered, &message); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to render template"})
+		return
+	}
+	message["instance_key"] = req.InstanceKey
+	message["phone"] = req.Phone
+	if req.To != "" {
+		message["to"] = req.To
+	}
+	if req.CustomMessageID != "" {
+		message["custom_message_id"] = req.CustomMessageID
+	}
+	if req.ReplyTo != "" {
+		message["reply_to"] = req.ReplyTo
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to render template"})
+		return
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	templateSendHandlers[template.Type](c)
+}
+
+// templateMessageVariables returns the request's variables plus the built-in
+// {{phone}}, which is the recipient
+func templateMessageVariables(req *types.TemplateMessageRequest) map[string]string {
+	variables := map[string]string{"phone": services.Recipient(req.To, req.Phone)}
+	for name, value := range req.Variables {
+		variables[name] = value
+	}
+	return variables
+}
+
+func CreateTemplate(c *gin.Context) {
+	var req types.TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	template, err := services.CreateTemplate(&req)
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, template)
+}
+
+func ListTemplates(c *gin.Context) {
+	templates, err := services.ListTemplates()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"templates": templates,
+		"count":     len(templates),
+	})
+}
+
+func GetTemplate(c *gin.Context) {
+	version, err := templateVersionQuery(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := services.GetTemplate(c.Param("name"), version)
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, template)
+}
+
+func ListTemplateVersions(c *gin.Context) {
+	name := c.Param("name")
+
+	versions, err := services.ListTemplateVersions(name)
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"name":     name,
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// UpdateTemplate stores the request as a new version of the template
+func UpdateTemplate(c *gin.Context) {
+	var req types.TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.Name = c.Param("name")
+
+	template, err := services.AddTemplateVersion(&req)
+	if err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, template)
+}
+
+func DeleteTemplate(c *gin.Context) {
+	version, err := templateVersionQuery(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.Param("name")
+	if err := services.DeleteTemplate(name, version); err != nil {
+		c.JSON(templateErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":  "deleted",
+		"name":    name,
+		"version": version,
+	})
+}
+
+// templateVersionQuery reads the optional ?version= query parameter, 0 means all or latest
+func templateVersionQuery(c *gin.Context) (int, error) {
+	value := c.Query("version")
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("version must be a positive number")
+	}
+	return version, nil
+}
+
+// templateErrorStatus maps template errors to HTTP status codes
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTemplateNotFound):
+		return 404
+	case errors.Is(err, services.ErrTemplateExists):
+		return 409
+	case errors.Is(err, services.ErrInvalidTemplate), errors.Is(err, services.ErrMissingTemplateVariables),
+		errors.Is(err, services.ErrInvalidTemplateVariables):
+		return 400
+	default:
+		return 500
+	}
+}
+
 func HandleWebhook(c *gin.Context) {
 	var msg types.IncomingMessage
 	if err := c.ShouldBindJSON(&msg); err != nil {
@@ -1220,6 +3118,7 @@ func handleTextMessage(instance *types.Instance, msg types.IncomingMessage) {
 		services.SendWebhook("message_error", gin.H{"instance_key": msg.InstanceKey, "phone": msg.From, "error": err.Error()}, msg.InstanceKey)
 		return
 	}
+	services.CacheSentMessage(instance, jid, resp, waMsg)
 
 	log.Printf("Sent text message to %s: %s (ID: %s)", msg.From, msg.Message, resp.ID)
 	services.SendWebhook("message_sent", gin.H{"instance_key": msg.InstanceKey, "phone": msg.From, "message_id": resp.ID}, msg.InstanceKey)
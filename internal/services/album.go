@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Album limits, WhatsApp apps group at most 30 items
+const (
+	MinAlbumItems          = 2
+	MaxAlbumItems          = 30
+	albumUploadConcurrency = 4
+)
+
+// ValidateAlbumItems checks the number and types of an album's items
+func ValidateAlbumItems(items []types.AlbumItem) error {
+	if len(items) < MinAlbumItems || len(items) > MaxAlbumItems {
+		return fmt.Errorf("an album needs %d to %d items", MinAlbumItems, MaxAlbumItems)
+	}
+	for i, item := range items {
+		if item.URL == "" {
+			return fmt.Errorf("item %d needs a url", i)
+		}
+		if item.Type != "image" && item.Type != "video" {
+			return fmt.Errorf("item %d: type must be image or video", i)
+		}
+	}
+	return nil
+}
+
+// UploadAlbumItems downloads and uploads the album's images and videos
+// concurrently and returns their messages in album order
+func UploadAlbumItems(inst *types.Instance, recipient whatsappTypes.JID, items []types.AlbumItem) ([]*waE2E.Message, error) {
+	messages := make([]*waE2E.Message, len(items))
+	errs := make([]error, len(items))
+	slots := make(chan struct{}, albumUploadConcurrency)
+
+	var wg sync.WaitGroup
+	for i := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			messages[i], errs[i] = uploadAlbumItem(inst, recipient, &items[i])
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	return messages, nil
+}
+
+// BuildAlbumMessage creates the parent message an album's items are attached to
+func BuildAlbumMessage(items []types.AlbumItem) *waE2E.Message {
+	var images, videos uint32
+	for _, item := range items {
+		if item.Type == "image" {
+			images++
+		} else {
+			videos++
+		}
+	}
+	return &waE2E.Message{
+		AlbumMessage: &waE2E.AlbumMessage{
+			ExpectedImageCount: proto.Uint32(images),
+			ExpectedVideoCount: proto.Uint32(videos),
+		},
+	}
+}
+
+// AttachToAlbum associates an item with the album's parent message, which is
+// what makes the apps show them grouped
+func AttachToAlbum(msg *waE2E.Message, chat whatsappTypes.JID, parentID string) {
+	if msg.MessageContextInfo == nil {
+		msg.MessageContextInfo = &waE2E.MessageContextInfo{}
+	}
+	msg.MessageContextInfo.MessageAssociation = &waE2E.MessageAssociation{
+		AssociationType: waE2E.MessageAssociation_MEDIA_ALBUM.Enum(),
+		ParentMessageKey: &waCommon.MessageKey{
+			RemoteJID: proto.String(chat.String()),
+			FromMe:    proto.Bool(true),
+			ID:        proto.String(parentID),
+		},
+	}
+}
+
+// uploadAlbumItem downloads one album item and uploads it to WhatsApp
+func uploadAlbumItem(inst *types.Instance, recipient whatsappTypes.JID, item *types.AlbumItem) (*waE2E.Message, error) {
+	data, err := DownloadMedia(item.URL)
+	if err != nil {
+		return nil, err
+	}
+	msg, _, err := BuildMediaMessage(inst, recipient, data, item.Type, MediaOptions{Caption: item.Caption})
+	return msg, err
+}
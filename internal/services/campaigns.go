@@ -0,0 +1,785 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"multi-client-whatsapp/internal/instance"
+	"multi-client-whatsapp/internal/platform/database"
+	"multi-client-whatsapp/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Campaign runner tuning
+const (
+	campaignInterval = 5 * time.Second
+	// campaignWindow is how many messages of a campaign may wait in the
+	// outbound queue at once. Keeping it small lets pausing take effect
+	// quickly and lets other traffic through.
+	campaignWindow = 10
+	maxRecipients  = 100000
+)
+
+// Campaign statuses
+const (
+	CampaignScheduled = "scheduled"
+	CampaignRunning   = "running"
+	CampaignPaused    = "paused"
+	CampaignCompleted = "completed"
+	CampaignCancelled = "cancelled"
+)
+
+// SendEndpoints maps message types to the send endpoint that sends them
+var SendEndpoints = map[string]string{
+	"text":        "/message/send",
+	"media":       "/message/send-media",
+	"contact":     "/message/send-contact",
+	"voice":       "/message/send-voice",
+	"location":    "/message/send-location",
+	"interactive": "/message/send-interactive",
+	"poll":        "/message/send-poll",
+}
+
+// recipientStatusRank orders recipient statuses so receipts only move a
+// recipient forward. A queued recipient's receipt can arrive before the
+// outcome of its queued message is picked up.
+var recipientStatusRank = map[string]int{"queued": 0, "sent": 1, "delivered": 2, "read": 3}
+
+var (
+	ErrCampaignNotFound = errors.New("campaign not found")
+	ErrCampaignState    = errors.New("campaign can't be changed in its current status")
+	ErrInvalidCampaign  = errors.New("invalid campaign")
+)
+
+// campaignPoolIndex is the round robin position in each running campaign's
+// instance pool, only used by the campaign loop
+var campaignPoolIndex = make(map[string]int)
+
+const campaignColumns = `id, name, instance_keys, message_type, message, priority, status, start_at,
+	created_at, updated_at, started_at, completed_at`
+
+const campaignRecipientColumns = `id, phone, resolved_phone, variables, status, instance_key, queue_id, message_id, error, updated_at`
+
+// StartCampaigns starts running due campaigns in the background
+func StartCampaigns() {
+	go func() {
+		ticker := time.NewTicker(campaignInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := runCampaigns(); err != nil {
+				log.Printf("Campaigns: %v", err)
+			}
+		}
+	}()
+	log.Printf("Campaign runner started")
+}
+
+// ParseRecipientsCSV reads recipients from a CSV with a header row. The
+// "phone" column (or the first column if there is none) is the number, every
+// other column becomes a template variable named after its header.
+func ParseRecipientsCSV(r io.Reader) ([]types.CampaignRecipientInput, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read CSV header: %v", ErrInvalidCampaign, err)
+	}
+	phoneColumn := 0
+	for i, name := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if strings.EqualFold(header[i], "phone") {
+			phoneColumn = i
+		}
+	}
+
+	var recipients []types.CampaignRecipientInput
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: CSV line %d: %v", ErrInvalidCampaign, line, err)
+		}
+		if len(record) <= phoneColumn || strings.TrimSpace(record[phoneColumn]) == "" {
+			continue
+		}
+
+		recipient := types.CampaignRecipientInput{
+			Phone:     strings.TrimSpace(record[phoneColumn]),
+			Variables: make(map[string]string),
+		}
+		for i, value := range record {
+			if i != phoneColumn && i < len(header) && header[i] != "" {
+				recipient.Variables[header[i]] = value
+			}
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// CreateCampaign validates and stores a campaign with its recipients
+func CreateCampaign(req *types.CampaignRequest) (*types.Campaign, error) {
+	campaign := &types.Campaign{
+		ID:           uuid.New().String(),
+		Name:         req.Name,
+		InstanceKeys: req.InstanceKeys,
+		MessageType:  firstNonEmpty(req.MessageType, "text"),
+		Priority:     firstNonEmpty(req.Priority, "low"),
+		Status:       CampaignScheduled,
+		StartAt:      time.Now(),
+	}
+	if req.InstanceKey != "" {
+		campaign.InstanceKeys = append([]string{req.InstanceKey}, campaign.InstanceKeys...)
+	}
+	if len(campaign.InstanceKeys) == 0 {
+		return nil, fmt.Errorf("%w: instance_key or instance_keys is required", ErrInvalidCampaign)
+	}
+	if _, ok := SendEndpoints[campaign.MessageType]; !ok {
+		return nil, fmt.Errorf("%w: unsupported message_type %q", ErrInvalidCampaign, campaign.MessageType)
+	}
+	if _, err := QueuePriority(campaign.Priority); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCampaign, err)
+	}
+	if req.StartAt != nil {
+		campaign.StartAt = *req.StartAt
+	}
+
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(req.Message, &message); err != nil || message == nil {
+		return nil, fmt.Errorf("%w: message must be a JSON object", ErrInvalidCampaign)
+	}
+	// Recipients and timing come from the campaign
+	stripMessageTarget(message)
+	var err error
+	if campaign.Message, err = json.Marshal(message); err != nil {
+		return nil, err
+	}
+
+	recipients := req.Recipients
+	if req.RecipientsCSV != "" {
+		csvRecipients, err := ParseRecipientsCSV(strings.NewReader(req.RecipientsCSV))
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, csvRecipients...)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%w: at least one recipient is required", ErrInvalidCampaign)
+	}
+	if len(recipients) > maxRecipients {
+		return nil, fmt.Errorf("%w: maximum %d recipients allowed", ErrInvalidCampaign, maxRecipients)
+	}
+
+	// Every recipient needs every variable, so no message goes out half filled
+	variables, err := TemplateVariables(campaign.Message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCampaign, err)
+	}
+	numberFields, err := TemplateNumberVariables(campaign.Message, campaign.MessageType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCampaign, err)
+	}
+	for i, recipient := range recipients {
+		if strings.TrimSpace(recipient.Phone) == "" {
+			return nil, fmt.Errorf("%w: recipient %d has no phone", ErrInvalidCampaign, i+1)
+		}
+		for _, name := range variables {
+			if _, ok := recipientVariables(recipient)[name]; !ok {
+				return nil, fmt.Errorf("%w: recipient %d (%s) is missing variable %q", ErrInvalidCampaign, i+1, recipient.Phone, name)
+			}
+		}
+		for field, name := range numberFields {
+			if value := strings.TrimSpace(recipientVariables(recipient)[name]); !jsonNumberRegex.MatchString(value) {
+				return nil, fmt.Errorf("%w: recipient %d (%s) needs a number in variable %q for %s", ErrInvalidCampaign, i+1, recipient.Phone, name, field)
+			}
+		}
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+	defer tx.Rollback()
+
+	instanceKeys, _ := json.Marshal(campaign.InstanceKeys)
+	campaign.CreatedAt = time.Now()
+	campaign.UpdatedAt = campaign.CreatedAt
+	_, err = tx.Exec(`INSERT INTO campaigns
+		(id, name, instance_keys, message_type, message, priority, status, start_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		campaign.ID, campaign.Name, string(instanceKeys), campaign.MessageType, string(campaign.Message),
+		campaign.Priority, campaign.Status, campaign.StartAt, campaign.CreatedAt, campaign.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("campaign_recipients", "campaign_id", "phone", "variables"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store recipients: %w", err)
+	}
+	for _, recipient := range recipients {
+		variables, _ := json.Marshal(recipient.Variables)
+		if recipient.Variables == nil {
+			variables = []byte("{}")
+		}
+		if _, err := stmt.Exec(campaign.ID, strings.TrimSpace(recipient.Phone), string(variables)); err != nil {
+			stmt.Close()
+			return nil, fmt.Errorf("failed to store recipients: %w", err)
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("failed to store recipients: %w", err)
+	}
+	stmt.Close()
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+
+	campaign.Stats = types.CampaignStats{Total: len(recipients), Pending: len(recipients)}
+	log.Printf("Campaign %s (%s) created with %d recipients", campaign.ID, campaign.Name, len(recipients))
+	return campaign, nil
+}
+
+// ListCampaigns returns the most recent campaigns, optionally filtered by status
+func ListCampaigns(status string) ([]types.Campaign, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+campaignColumns+` FROM campaigns
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC LIMIT 100`, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	campaigns := []types.Campaign{}
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+	rows.Close()
+
+	for i := range campaigns {
+		if err := loadCampaignStats(db, &campaigns[i]); err != nil {
+			return nil, err
+		}
+	}
+	return campaigns, nil
+}
+
+// GetCampaign returns a campaign with its recipient counts
+func GetCampaign(id string) (*types.Campaign, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	campaign, err := scanCampaign(db.QueryRow(`SELECT `+campaignColumns+` FROM campaigns WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := loadCampaignStats(db, campaign); err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// GetCampaignRecipients returns the recipients of a campaign, optionally filtered by status
+func GetCampaignRecipients(id, status string) ([]types.CampaignRecipient, error) {
+	if _, err := GetCampaign(id); err != nil {
+		return nil, err
+	}
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+campaignRecipientColumns+` FROM campaign_recipients
+		WHERE campaign_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id`, id, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recipients: %w", err)
+	}
+	defer rows.Close()
+
+	recipients := []types.CampaignRecipient{}
+	for rows.Next() {
+		recipient, err := scanCampaignRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, *recipient)
+	}
+	return recipients, rows.Err()
+}
+
+// WriteCampaignReportCSV writes the per-recipient report of a campaign as CSV
+func WriteCampaignReportCSV(w io.Writer, recipients []types.CampaignRecipient) error {
+	// Variables become extra columns, in a stable order
+	variableSet := make(map[string]bool)
+	for _, recipient := range recipients {
+		for name := range recipient.Variables {
+			variableSet[name] = true
+		}
+	}
+	variables := make([]string, 0, len(variableSet))
+	for name := range variableSet {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+
+	writer := csv.NewWriter(w)
+	header := append([]string{"phone", "resolved_phone", "status", "instance_key", "message_id", "error", "updated_at"}, variables...)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		record := []string{
+			recipient.Phone,
+			recipient.ResolvedPhone,
+			recipient.Status,
+			recipient.InstanceKey,
+			recipient.MessageID,
+			recipient.Error,
+			recipient.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+		for _, name := range variables {
+			record = append(record, recipient.Variables[name])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// PauseCampaign stops sending a campaign. Its messages still waiting in the
+// outbound queue are taken back, so they are sent on resume.
+func PauseCampaign(id string) (*types.Campaign, error) {
+	if err := setCampaignStatus(id, CampaignPaused, CampaignScheduled, CampaignRunning); err != nil {
+		return nil, err
+	}
+	if err := withdrawQueuedRecipients(id, "pending"); err != nil {
+		return nil, err
+	}
+	return GetCampaign(id)
+}
+
+// ResumeCampaign continues a paused campaign
+func ResumeCampaign(id string) (*types.Campaign, error) {
+	if err := setCampaignStatus(id, CampaignRunning, CampaignPaused); err != nil {
+		return nil, err
+	}
+	return GetCampaign(id)
+}
+
+// CancelCampaign stops a campaign for good, recipients that weren't sent yet are cancelled
+func CancelCampaign(id string) (*types.Campaign, error) {
+	if err := setCampaignStatus(id, CampaignCancelled, CampaignScheduled, CampaignRunning, CampaignPaused); err != nil {
+		return nil, err
+	}
+	if err := withdrawQueuedRecipients(id, "cancelled"); err != nil {
+		return nil, err
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`UPDATE campaign_recipients SET status = 'cancelled', updated_at = now()
+		WHERE campaign_id = $1 AND status = 'pending'`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel recipients: %w", err)
+	}
+	_, err = db.Exec(`UPDATE campaigns SET completed_at = now() WHERE id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel campaign: %w", err)
+	}
+	return GetCampaign(id)
+}
+
+// RecordCampaignReceipt moves campaign recipients to delivered or read when
+// their message's receipt comes in
+func RecordCampaignReceipt(evt *events.Receipt) {
+	var status string
+	switch evt.Type {
+	case whatsappTypes.ReceiptTypeDelivered:
+		status = "delivered"
+	case whatsappTypes.ReceiptTypeRead, whatsappTypes.ReceiptTypePlayed:
+		status = "read"
+	default:
+		return
+	}
+	if len(evt.MessageIDs) == 0 {
+		return
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		// Without the bridge database there are no campaigns to update
+		return
+	}
+
+	var lower []string
+	for name, rank := range recipientStatusRank {
+		if rank < recipientStatusRank[status] {
+			lower = append(lower, name)
+		}
+	}
+	// Recipients whose queued message was just sent only have its ID in the queue
+	_, err = db.Exec(`UPDATE campaign_recipients r SET status = $1,
+			message_id = COALESCE((SELECT q.message_id FROM outbound_queue q
+				WHERE q.id = r.queue_id AND q.message_id = ANY($2)), r.message_id),
+			updated_at = now()
+		WHERE r.status = ANY($3) AND (r.message_id = ANY($2) OR r.queue_id IN (
+			SELECT id FROM outbound_queue WHERE message_id = ANY($2)
+		))`,
+		status, pq.Array(evt.MessageIDs), pq.Array(lower))
+	if err != nil {
+		log.Printf("Campaigns: failed to record receipt: %v", err)
+	}
+}
+
+// setCampaignStatus changes the status of a campaign that is in one of the given statuses
+func setCampaignStatus(id, status string, from ...string) error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`UPDATE campaigns SET status = $2, updated_at = now()
+		WHERE id = $1 AND status = ANY($3)`, id, status, pq.Array(from))
+	if err != nil {
+		return fmt.Errorf("failed to update campaign: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		if _, err := GetCampaign(id); err != nil {
+			return err
+		}
+		return ErrCampaignState
+	}
+	log.Printf("Campaign %s %s", id, status)
+	return nil
+}
+
+// withdrawQueuedRecipients cancels the campaign's messages still pending in
+// the outbound queue and gives their recipients the given status
+func withdrawQueuedRecipients(id, status string) error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`WITH withdrawn AS (
+			UPDATE outbound_queue SET status = 'cancelled', updated_at = now()
+			WHERE status = 'pending' AND id IN (
+				SELECT queue_id FROM campaign_recipients WHERE campaign_id = $1 AND status = 'queued'
+			)
+			RETURNING id
+		)
+		UPDATE campaign_recipients SET status = $2, queue_id = '', updated_at = now()
+		WHERE campaign_id = $1 AND status = 'queued' AND queue_id IN (SELECT id FROM withdrawn)`, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to withdraw queued messages: %w", err)
+	}
+	return nil
+}
+
+// runCampaigns starts due campaigns, tracks their queued messages and
+// feeds the next recipients of every running campaign into the queue
+func runCampaigns() error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`UPDATE campaigns SET status = 'running', started_at = now(), updated_at = now()
+		WHERE status = 'scheduled' AND start_at <= now()`)
+	if err != nil {
+		return fmt.Errorf("failed to start campaigns: %w", err)
+	}
+
+	// Pick up the outcome of queued messages
+	_, err = db.Exec(`UPDATE campaign_recipients r
+		SET status = q.status,
+			message_id = q.message_id, error = q.last_error, updated_at = now()
+		FROM outbound_queue q
+		WHERE r.queue_id = q.id AND r.status = 'queued' AND q.status IN ('sent', 'failed', 'cancelled')`)
+	if err != nil {
+		return fmt.Errorf("failed to sync queued recipients: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT ` + campaignColumns + ` FROM campaigns WHERE status = 'running' ORDER BY created_at`)
+	if err != nil {
+		return fmt.Errorf("failed to load running campaigns: %w", err)
+	}
+	var running []*types.Campaign
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		running = append(running, campaign)
+	}
+	rows.Close()
+
+	// Campaigns that finished, were cancelled or paused start over in their pool
+	isRunning := make(map[string]bool, len(running))
+	for _, campaign := range running {
+		isRunning[campaign.ID] = true
+	}
+	for id := range campaignPoolIndex {
+		if !isRunning[id] {
+			delete(campaignPoolIndex, id)
+		}
+	}
+
+	for _, campaign := range running {
+		if err := feedCampaign(db, campaign); err != nil {
+			log.Printf("Campaigns: campaign %s: %v", campaign.ID, err)
+		}
+	}
+
+	_, err = db.Exec(`UPDATE campaigns c SET status = 'completed', completed_at = now(), updated_at = now()
+		WHERE status = 'running' AND NOT EXISTS (
+			SELECT 1 FROM campaign_recipients r WHERE r.campaign_id = c.id AND r.status IN ('pending', 'queued')
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to complete campaigns: %w", err)
+	}
+	return nil
+}
+
+// feedCampaign hands pending recipients to the outbound queue until the campaign's window is full
+func feedCampaign(db *sql.DB, campaign *types.Campaign) error {
+	var queued int
+	if err := db.QueryRow(`SELECT count(*) FROM campaign_recipients WHERE campaign_id = $1 AND status = 'queued'`, campaign.ID).Scan(&queued); err != nil {
+		return err
+	}
+	if queued >= campaignWindow {
+		return nil
+	}
+
+	rows, err := db.Query(`SELECT `+campaignRecipientColumns+` FROM campaign_recipients
+		WHERE campaign_id = $1 AND status = 'pending'
+		ORDER BY id LIMIT $2`, campaign.ID, campaignWindow-queued)
+	if err != nil {
+		return err
+	}
+	var pending []*types.CampaignRecipient
+	for rows.Next() {
+		recipient, err := scanCampaignRecipient(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, recipient)
+	}
+	rows.Close()
+
+	for _, recipient := range pending {
+		inst := nextCampaignInstance(campaign)
+		if inst == nil {
+			// No instance of the pool is connected, try again later
+			return nil
+		}
+		dispatchCampaignRecipient(campaign, recipient, inst)
+
+		_, err := db.Exec(`UPDATE campaign_recipients
+			SET status = $2, resolved_phone = $3, instance_key = $4, queue_id = $5, message_id = $6, error = $7, updated_at = now()
+			WHERE id = $1`,
+			recipient.ID, recipient.Status, recipient.ResolvedPhone, recipient.InstanceKey,
+			recipient.QueueID, recipient.MessageID, recipient.Error)
+		if err != nil {
+			return fmt.Errorf("failed to update recipient %d: %w", recipient.ID, err)
+		}
+	}
+	return nil
+}
+
+// dispatchCampaignRecipient resolves the recipient, renders the
+// message and queues it through the send endpoint. The outcome is left in
+// the recipient's fields.
+func dispatchCampaignRecipient(campaign *types.Campaign, recipient *types.CampaignRecipient, inst *types.Instance) {
+	recipient.InstanceKey = inst.ID
+
+	jid, err := ResolveChatJID(recipient.Phone, inst)
+	if err != nil {
+		recipient.Status = "failed"
+		recipient.Error = fmt.Sprintf("invalid recipient: %v", err)
+		return
+	}
+	phone := jid.String()
+	recipient.ResolvedPhone = phone
+
+	rendered, err := RenderTemplate(campaign.Message, campaign.MessageType, recipientVariables(types.CampaignRecipientInput{Phone: recipient.Phone, Variables: recipient.Variables}))
+	if err != nil {
+		recipient.Status = "failed"
+		recipient.Error = err.Error()
+		return
+	}
+
+	var body map[string]json.RawMessage
+	json.Unmarshal(rendered, &body)
+	body["instance_key"], _ = json.Marshal(inst.ID)
+	body["phone"], _ = json.Marshal(phone)
+	body["priority"], _ = json.Marshal(campaign.Priority)
+	body["async"] = json.RawMessage("true")
+	payload, _ := json.Marshal(body)
+
+	code, respBody := replaySendRequest(context.Background(), SendEndpoints[campaign.MessageType], payload)
+	var resp struct {
+		QueueID   string `json:"queue_id"`
+		MessageID string `json:"message_id"`
+		Error     string `json:"error"`
+	}
+	json.Unmarshal(respBody, &resp)
+
+	switch {
+	case code == http.StatusAccepted && resp.QueueID != "":
+		recipient.Status = "queued"
+		recipient.QueueID = resp.QueueID
+	case code == http.StatusOK:
+		// The queue was unavailable and the message went out directly
+		recipient.Status = "sent"
+		recipient.MessageID = resp.MessageID
+	case instanceOffline(code, respBody):
+		// Try again on the next round
+		recipient.Status = "pending"
+	default:
+		recipient.Status = "failed"
+		recipient.Error = firstNonEmpty(resp.Error, string(respBody))
+	}
+}
+
+// nextCampaignInstance returns the next connected instance of the campaign's pool, round robin
+func nextCampaignInstance(campaign *types.Campaign) *types.Instance {
+	for i := 0; i < len(campaign.InstanceKeys); i++ {
+		index := campaignPoolIndex[campaign.ID] % len(campaign.InstanceKeys)
+		campaignPoolIndex[campaign.ID] = index + 1
+
+		instanceKey := campaign.InstanceKeys[index]
+		if !instanceConnected(instanceKey) {
+			continue
+		}
+		instance.Manager.Mutex.RLock()
+		inst := instance.Manager.Instances[instanceKey]
+		instance.Manager.Mutex.RUnlock()
+		if inst != nil {
+			return inst
+		}
+	}
+	return nil
+}
+
+// recipientVariables returns a recipient's variables plus the built-in {{phone}}
+func recipientVariables(recipient types.CampaignRecipientInput) map[string]string {
+	variables := make(map[string]string, len(recipient.Variables)+1)
+	variables["phone"] = recipient.Phone
+	for name, value := range recipient.Variables {
+		variables[name] = value
+	}
+	return variables
+}
+
+func loadCampaignStats(db *sql.DB, campaign *types.Campaign) error {
+	rows, err := db.Query(`SELECT status, count(*) FROM campaign_recipients WHERE campaign_id = $1 GROUP BY status`, campaign.ID)
+	if err != nil {
+		return fmt.Errorf("failed to count recipients: %w", err)
+	}
+	defer rows.Close()
+
+	stats := types.CampaignStats{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return err
+		}
+		stats.Total += count
+		switch status {
+		case "pending":
+			stats.Pending = count
+		case "queued":
+			stats.Queued = count
+		case "sent":
+			stats.Sent = count
+		case "delivered":
+			stats.Delivered = count
+		case "read":
+			stats.Read = count
+		case "failed":
+			stats.Failed = count
+		case "cancelled":
+			stats.Cancelled = count
+		}
+	}
+	campaign.Stats = stats
+	return rows.Err()
+}
+
+func scanCampaign(row rowScanner) (*types.Campaign, error) {
+	campaign := &types.Campaign{}
+	var instanceKeys, message []byte
+	var startedAt, completedAt sql.NullTime
+	err := row.Scan(&campaign.ID, &campaign.Name, &instanceKeys, &campaign.MessageType, &message, &campaign.Priority,
+		&campaign.Status, &campaign.StartAt, &campaign.CreatedAt, &campaign.UpdatedAt, &startedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal(instanceKeys, &campaign.InstanceKeys)
+	campaign.Message = message
+	if startedAt.Valid {
+		campaign.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		campaign.CompletedAt = &completedAt.Time
+	}
+	return campaign, nil
+}
+
+func scanCampaignRecipient(row rowScanner) (*types.CampaignRecipient, error) {
+	recipient := &types.CampaignRecipient{}
+	var variables []byte
+	err := row.Scan(&recipient.ID, &recipient.Phone, &recipient.ResolvedPhone, &variables, &recipient.Status,
+		&recipient.InstanceKey, &recipient.QueueID, &recipient.MessageID, &recipient.Error, &recipient.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal(variables, &recipient.Variables)
+	return recipient, nil
+}
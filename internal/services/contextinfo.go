@@ -0,0 +1,149 @@
+package services
+
+import (
+	"log"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// GetContextInfo returns the ContextInfo of a message's content, or nil if it has none
+func GetContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetViewOnceMessage().GetMessage() != nil:
+		return GetContextInfo(msg.GetViewOnceMessage().GetMessage())
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	case msg.GetContactMessage() != nil:
+		return msg.GetContactMessage().GetContextInfo()
+	case msg.GetContactsArrayMessage() != nil:
+		return msg.GetContactsArrayMessage().GetContextInfo()
+	case msg.GetLocationMessage() != nil:
+		return msg.GetLocationMessage().GetContextInfo()
+	case msg.GetLiveLocationMessage() != nil:
+		return msg.GetLiveLocationMessage().GetContextInfo()
+	case msg.GetButtonsMessage() != nil:
+		return msg.GetButtonsMessage().GetContextInfo()
+	case msg.GetListMessage() != nil:
+		return msg.GetListMessage().GetContextInfo()
+	case msg.GetInteractiveMessage() != nil:
+		return msg.GetInteractiveMessage().GetContextInfo()
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage().GetContextInfo()
+	case msg.GetAlbumMessage() != nil:
+		return msg.GetAlbumMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// EnsureContextInfo returns the ContextInfo of a message's content, attaching
+// an empty one first if needed. Returns nil for content that can't carry one.
+func EnsureContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	if ctx := GetContextInfo(msg); ctx != nil {
+		return ctx
+	}
+
+	if inner := msg.GetViewOnceMessage().GetMessage(); inner != nil {
+		return EnsureContextInfo(inner)
+	}
+
+	ctx := &waE2E.ContextInfo{}
+	switch {
+	case msg.ExtendedTextMessage != nil:
+		msg.ExtendedTextMessage.ContextInfo = ctx
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ContextInfo = ctx
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ContextInfo = ctx
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ContextInfo = ctx
+	case msg.DocumentMessage != nil:
+		msg.DocumentMessage.ContextInfo = ctx
+	case msg.StickerMessage != nil:
+		msg.StickerMessage.ContextInfo = ctx
+	case msg.ContactMessage != nil:
+		msg.ContactMessage.ContextInfo = ctx
+	case msg.ContactsArrayMessage != nil:
+		msg.ContactsArrayMessage.ContextInfo = ctx
+	case msg.LocationMessage != nil:
+		msg.LocationMessage.ContextInfo = ctx
+	case msg.LiveLocationMessage != nil:
+		msg.LiveLocationMessage.ContextInfo = ctx
+	case msg.ButtonsMessage != nil:
+		msg.ButtonsMessage.ContextInfo = ctx
+	case msg.ListMessage != nil:
+		msg.ListMessage.ContextInfo = ctx
+	case msg.InteractiveMessage != nil:
+		msg.InteractiveMessage.ContextInfo = ctx
+	case msg.PollCreationMessage != nil:
+		msg.PollCreationMessage.ContextInfo = ctx
+	case msg.AlbumMessage != nil:
+		msg.AlbumMessage.ContextInfo = ctx
+	default:
+		return nil
+	}
+	return ctx
+}
+
+// ApplyReplyContext turns a message into a quoted reply to replyTo. The
+// original message is looked up in the message cache so the full quote
+// (sender, chat and quoted content) can be filled in; if it isn't cached
+// only the stanza ID is set and WhatsApp shows the reply without the quote.
+func ApplyReplyContext(inst *types.Instance, chat whatsappTypes.JID, msg *waE2E.Message, replyTo string) {
+	if replyTo == "" {
+		return
+	}
+
+	ctx := EnsureContextInfo(msg)
+	if ctx == nil {
+		log.Printf("Warning: message type doesn't support replies, ignoring reply_to %s", replyTo)
+		return
+	}
+	ctx.StanzaID = proto.String(replyTo)
+
+	original, ok := GetCachedMessage(inst.ID, replyTo)
+	if !ok {
+		log.Printf("Warning: message %s not found in cache, sending reply without quoted message", replyTo)
+		return
+	}
+
+	sender := original.Info.Sender
+	if original.Info.IsFromMe && inst.Client.Store.ID != nil {
+		sender = *inst.Client.Store.ID
+	}
+	if !sender.IsEmpty() {
+		ctx.Participant = proto.String(sender.ToNonAD().String())
+	}
+	// RemoteJID is only needed when quoting a message from another chat
+	if original.Info.Chat.ToNonAD() != chat.ToNonAD() {
+		ctx.RemoteJID = proto.String(original.Info.Chat.String())
+	}
+	ctx.QuotedMessage = quotableMessage(original.Message)
+}
+
+// quotableMessage returns a copy of a message suitable for embedding as a
+// quote, without the original's own reply context
+func quotableMessage(msg *waE2E.Message) *waE2E.Message {
+	quoted := proto.Clone(msg).(*waE2E.Message)
+	quoted.MessageContextInfo = nil
+	if ctx := GetContextInfo(quoted); ctx != nil {
+		ctx.StanzaID = nil
+		ctx.Participant = nil
+		ctx.RemoteJID = nil
+		ctx.QuotedMessage = nil
+	}
+	return quoted
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/platform/database"
+	"multi-client-whatsapp/internal/types"
+
+	"github.com/lib/pq"
+	"go.mau.fi/whatsmeow"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var ErrMessageStatusNotFound = errors.New("no delivery status recorded for this message")
+
+// deliveryStatuses are the statuses of a sent message in the order they are reached
+var deliveryStatuses = []string{"sent", "server_ack", "delivered", "read", "played"}
+
+// MaxMessageStatusLimit caps how many messages a chat listing returns
+const MaxMessageStatusLimit = 500
+
+// messageStatusCleanupPeriod is how often old statuses are swept
+const messageStatusCleanupPeriod = time.Hour
+
+// messageStatusRetention is how long delivery statuses and message metadata
+// are kept, MESSAGE_STATUS_RETENTION overrides it
+var messageStatusRetention = 30 * 24 * time.Hour
+
+var (
+	lastMessageStatusCleanup time.Time
+	messageStatusMutex       sync.Mutex
+)
+
+func init() {
+	if value := os.Getenv("MESSAGE_STATUS_RETENTION"); value != "" {
+		if retention, err := time.ParseDuration(value); err == nil && retention > 0 {
+			messageStatusRetention = retention
+		} else {
+			log.Printf("Invalid MESSAGE_STATUS_RETENTION %q, using %s", value, messageStatusRetention)
+		}
+	}
+}
+
+// messageStatusQuery selects message statuses with the metadata given when sending them
+const messageStatusQuery = `SELECT s.instance_key, s.message_id, s.chat, s.status, s.sent_at, s.server_ack_at,
+	s.delivered_at, s.read_at, s.played_at, s.updated_at, m.metadata
+	FROM message_status s
+	LEFT JOIN message_metadata m ON m.instance_key = s.instance_key AND m.message_id = s.message_id`
+
+// RecordSentMessage starts tracking the delivery status of a message the
+// instance sent. A send that returned was acknowledged by the server.
+func RecordSentMessage(instanceKey string, chat whatsappTypes.JID, resp whatsmeow.SendResponse) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		// Without the bridge database delivery status isn't tracked
+		return
+	}
+
+	status := "server_ack"
+	serverAck := sql.NullTime{Time: resp.Timestamp, Valid: !resp.Timestamp.IsZero()}
+	if !serverAck.Valid {
+		status = "sent"
+	}
+	_, err = db.Exec(`INSERT INTO message_status (instance_key, message_id, chat, status, sent_at, server_ack_at)
+		VALUES ($1, $2, $3, $4, now(), $5)
+		ON CONFLICT (instance_key, message_id) DO NOTHING`,
+		instanceKey, resp.ID, chat.ToNonAD().String(), status, serverAck)
+	if err != nil {
+		log.Printf("Delivery status: failed to record message %s: %v", resp.ID, err)
+	}
+	cleanupMessageStatuses(db)
+}
+
+// RecordDeliveryReceipt moves the messages of a receipt forward to delivered,
+// read or played. Group receipts are also recorded for their participant.
+// Receipts for messages the bridge didn't send are ignored.
+func RecordDeliveryReceipt(instanceKey string, evt *events.Receipt) {
+	var status string
+	switch evt.Type {
+	case whatsappTypes.ReceiptTypeDelivered:
+		status = "delivered"
+	case whatsappTypes.ReceiptTypeRead:
+		status = "read"
+	case whatsappTypes.ReceiptTypePlayed:
+		status = "played"
+	default:
+		return
+	}
+	if len(evt.MessageIDs) == 0 {
+		return
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return
+	}
+
+	// A read implies delivered and played implies read, even when the
+	// earlier receipts never arrived
+	var delivered, read, played sql.NullTime
+	switch status {
+	case "played":
+		played = sql.NullTime{Time: evt.Timestamp, Valid: true}
+		fallthrough
+	case "read":
+		read = sql.NullTime{Time: evt.Timestamp, Valid: true}
+		fallthrough
+	default:
+		delivered = sql.NullTime{Time: evt.Timestamp, Valid: true}
+	}
+
+	_, err = db.Exec(`UPDATE message_status SET
+			status = CASE WHEN array_position($3::text[], status) < array_position($3::text[], $4) THEN $4 ELSE status END,
+			delivered_at = COALESCE(delivered_at, $5),
+			read_at = COALESCE(read_at, $6),
+			played_at = COALESCE(played_at, $7),
+			updated_at = now()
+		WHERE instance_key = $1 AND message_id = ANY($2)`,
+		instanceKey, pq.Array(evt.MessageIDs), pq.Array(deliveryStatuses), status, delivered, read, played)
+	if err != nil {
+		log.Printf("Delivery status: failed to record receipt: %v", err)
+		return
+	}
+
+	if !evt.IsGroup {
+		return
+	}
+	_, err = db.Exec(`INSERT INTO message_status_participants
+			(instance_key, message_id, participant, status, delivered_at, read_at, played_at)
+		SELECT instance_key, message_id, $3::text, $5::text, $6::timestamptz, $7::timestamptz, $8::timestamptz FROM message_status
+		WHERE instance_key = $1 AND message_id = ANY($2)
+		ON CONFLICT (instance_key, message_id, participant) DO UPDATE SET
+			status = CASE WHEN array_position($4::text[], message_status_participants.status) < array_position($4::text[], $5)
+				THEN $5 ELSE message_status_participants.status END,
+			delivered_at = COALESCE(message_status_participants.delivered_at, $6),
+			read_at = COALESCE(message_status_participants.read_at, $7),
+			played_at = COALESCE(message_status_participants.played_at, $8),
+			updated_at = now()`,
+		instanceKey, pq.Array(evt.MessageIDs), evt.Sender.ToNonAD().String(), pq.Array(deliveryStatuses),
+		status, delivered, read, played)
+	if err != nil {
+		log.Printf("Delivery status: failed to record participant receipt: %v", err)
+	}
+}
+
+// GetMessageStatus returns the delivery status of a sent message, with the
+// status per participant for group messages
+func GetMessageStatus(instanceKey, messageID string) (*types.MessageStatus, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := scanMessageStatus(db.QueryRow(messageStatusQuery+`
+		WHERE s.instance_key = $1 AND s.message_id = $2`, instanceKey, messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageStatusNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message status: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT participant, status, delivered_at, read_at, played_at, updated_at
+		FROM message_status_participants
+		WHERE instance_key = $1 AND message_id = $2
+		ORDER BY participant`, instanceKey, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load participant statuses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var participant types.ParticipantMessageStatus
+		var delivered, read, played sql.NullTime
+		if err := rows.Scan(&participant.Participant, &participant.Status, &delivered, &read, &played,
+			&participant.UpdatedAt); err != nil {
+			return nil, err
+		}
+		participant.DeliveredAt = timePtr(delivered)
+		participant.ReadAt = timePtr(read)
+		participant.PlayedAt = timePtr(played)
+		status.Participants = append(status.Participants, participant)
+	}
+	return status, rows.Err()
+}
+
+// ListChatMessageStatuses returns the delivery status of the most recent
+// messages sent to a chat, newest first
+func ListChatMessageStatuses(instanceKey string, chat whatsappTypes.JID, limit int) ([]types.MessageStatus, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(messageStatusQuery+`
+		WHERE s.instance_key = $1 AND s.chat = $2
+		ORDER BY s.sent_at DESC
+		LIMIT $3`, instanceKey, chat.ToNonAD().String(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := []types.MessageStatus{}
+	for rows.Next() {
+		status, err := scanMessageStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, rows.Err()
+}
+
+func scanMessageStatus(row rowScanner) (*types.MessageStatus, error) {
+	status := &types.MessageStatus{}
+	var serverAck, delivered, read, played sql.NullTime
+	var metadata []byte
+	err := row.Scan(&status.InstanceKey, &status.MessageID, &status.Chat, &status.Status, &status.SentAt,
+		&serverAck, &delivered, &read, &played, &status.UpdatedAt, &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		status.Metadata = metadata
+	}
+	status.ServerAckAt = timePtr(serverAck)
+	status.DeliveredAt = timePtr(delivered)
+	status.ReadAt = timePtr(read)
+	status.PlayedAt = timePtr(played)
+	return status, nil
+}
+
+// DeleteMessageStatuses drops the delivery statuses and message metadata of
+// an instance when it's deleted
+func DeleteMessageStatuses(instanceKey string) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return
+	}
+	// Participant statuses go with their message
+	if _, err := db.Exec(`DELETE FROM message_status WHERE instance_key = $1`, instanceKey); err != nil {
+		log.Printf("Warning: Error deleting message statuses of instance %s: %v", instanceKey, err)
+	}
+	if _, err := db.Exec(`DELETE FROM message_metadata WHERE instance_key = $1`, instanceKey); err != nil {
+		log.Printf("Warning: Error deleting message metadata of instance %s: %v", instanceKey, err)
+	}
+}
+
+// cleanupMessageStatuses drops statuses and metadata older than the
+// retention every now and then
+func cleanupMessageStatuses(db *sql.DB) {
+	messageStatusMutex.Lock()
+	if time.Since(lastMessageStatusCleanup) < messageStatusCleanupPeriod {
+		messageStatusMutex.Unlock()
+		return
+	}
+	lastMessageStatusCleanup = time.Now()
+	messageStatusMutex.Unlock()
+
+	cutoff := time.Now().Add(-messageStatusRetention)
+	if _, err := db.Exec(`DELETE FROM message_status WHERE sent_at < $1`, cutoff); err != nil {
+		log.Printf("Warning: Error deleting old message statuses: %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM message_metadata WHERE created_at < $1`, cutoff); err != nil {
+		log.Printf("Warning: Error deleting old message metadata: %v", err)
+	}
+}
+
+// timePtr returns the time of a nullable column, or nil if it's NULL
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
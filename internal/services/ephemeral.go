@@ -0,0 +1,119 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// disappearingTimers are the timers WhatsApp apps support, in seconds
+var disappearingTimers = map[uint32]bool{
+	0:       true,
+	86400:   true, // 24 hours
+	604800:  true, // 7 days
+	7776000: true, // 90 days
+}
+
+// ParseDisappearingTimer parses a disappearing messages timer like "off",
+// "24h", "7d", "90d" or a number of seconds
+func ParseDisappearingTimer(value string) (time.Duration, error) {
+	timer, ok := whatsmeow.ParseDisappearingTimerString(value)
+	if !ok {
+		return 0, fmt.Errorf("timer must be one of: off, 24h, 7d, 90d")
+	}
+	return timer, nil
+}
+
+// ValidateEphemeralExpiration checks a disappearing message expiration in seconds
+func ValidateEphemeralExpiration(seconds uint32) error {
+	if !disappearingTimers[seconds] {
+		return fmt.Errorf("ephemeral_expiration must be one of: 0, 86400 (24h), 604800 (7d), 7776000 (90d)")
+	}
+	return nil
+}
+
+// ApplyEphemeralExpiration marks a message as disappearing after the given
+// number of seconds. It should match the chat's timer, otherwise the apps
+// show a notice that the timer was changed.
+func ApplyEphemeralExpiration(msg *waE2E.Message, seconds uint32) {
+	if seconds == 0 {
+		return
+	}
+	if ctx := EnsureContextInfo(msg); ctx != nil {
+		ctx.Expiration = proto.Uint32(seconds)
+	}
+}
+
+// WrapViewOnce turns an image, video or voice note message into a view once message
+func WrapViewOnce(msg *waE2E.Message) (*waE2E.Message, error) {
+	switch {
+	case msg.GetImageMessage() != nil:
+		msg.ImageMessage.ViewOnce = proto.Bool(true)
+	case msg.GetVideoMessage() != nil:
+		msg.VideoMessage.ViewOnce = proto.Bool(true)
+	case msg.GetAudioMessage().GetPTT():
+		msg.AudioMessage.ViewOnce = proto.Bool(true)
+	default:
+		return nil, fmt.Errorf("view_once is only supported for images, videos and voice notes")
+	}
+	return &waE2E.Message{
+		ViewOnceMessage: &waE2E.FutureProofMessage{Message: msg},
+	}, nil
+}
+
+// UnwrapMessage strips the ephemeral, view once and other wrappers a message
+// can arrive in down to its content. whatsmeow already does this for live
+// messages, this catches the ones that still come wrapped.
+func UnwrapMessage(msg *waE2E.Message) *waE2E.Message {
+	for {
+		inner := unwrapMessageLayer(msg)
+		if inner == nil {
+			return msg
+		}
+		msg = inner
+	}
+}
+
+// unwrapMessageLayer returns the message inside a wrapper, or nil if msg isn't wrapped
+func unwrapMessageLayer(msg *waE2E.Message) *waE2E.Message {
+	switch {
+	case msg.GetDeviceSentMessage().GetMessage() != nil:
+		return msg.GetDeviceSentMessage().GetMessage()
+	case msg.GetEphemeralMessage().GetMessage() != nil:
+		return msg.GetEphemeralMessage().GetMessage()
+	case msg.GetViewOnceMessage().GetMessage() != nil:
+		return msg.GetViewOnceMessage().GetMessage()
+	case msg.GetViewOnceMessageV2().GetMessage() != nil:
+		return msg.GetViewOnceMessageV2().GetMessage()
+	case msg.GetViewOnceMessageV2Extension().GetMessage() != nil:
+		return msg.GetViewOnceMessageV2Extension().GetMessage()
+	case msg.GetDocumentWithCaptionMessage().GetMessage() != nil:
+		return msg.GetDocumentWithCaptionMessage().GetMessage()
+	}
+	return nil
+}
+
+// unwrapMessageEvent returns the event with its message content unwrapped,
+// as a copy so the original event is left alone
+func unwrapMessageEvent(evt *events.Message) *events.Message {
+	content := UnwrapMessage(evt.Message)
+	if content == evt.Message {
+		return evt
+	}
+
+	unwrapped := *evt
+	unwrapped.Message = content
+	for msg := evt.Message; msg != content; msg = unwrapMessageLayer(msg) {
+		if msg.GetEphemeralMessage() != nil {
+			unwrapped.IsEphemeral = true
+		}
+		if msg.GetViewOnceMessage() != nil || msg.GetViewOnceMessageV2() != nil || msg.GetViewOnceMessageV2Extension() != nil {
+			unwrapped.IsViewOnce = true
+		}
+	}
+	return &unwrapped
+}
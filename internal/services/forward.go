@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrForwardSourceNotFound = errors.New("message not found in the recent message cache")
+	ErrNotForwardable        = errors.New("message can't be forwarded")
+)
+
+// BuildForwardMessage returns a copy of a message's content marked as
+// forwarded. Media keeps its upload references, so it's re-sent without
+// downloading and uploading it again.
+func BuildForwardMessage(inst *types.Instance, original *waE2E.Message) (*waE2E.Message, error) {
+	content := unwrapForwardContent(original)
+	switch {
+	case content == nil:
+		return nil, ErrNotForwardable
+	case content.GetViewOnceMessage() != nil || content.GetViewOnceMessageV2() != nil || content.GetViewOnceMessageV2Extension() != nil:
+		return nil, fmt.Errorf("%w: view once messages can't be forwarded", ErrNotForwardable)
+	case content.GetLiveLocationMessage() != nil:
+		return nil, fmt.Errorf("%w: live locations can't be forwarded", ErrNotForwardable)
+	case content.GetProtocolMessage() != nil || content.GetReactionMessage() != nil || content.GetPollUpdateMessage() != nil:
+		return nil, fmt.Errorf("%w: only message content can be forwarded", ErrNotForwardable)
+	}
+
+	var forwarded *waE2E.Message
+	previous := GetContextInfo(content)
+	if poll := pollCreation(content); poll != nil {
+		// Votes are encrypted with the poll's secret, a forwarded poll needs a new one
+		options := make([]string, 0, len(poll.GetOptions()))
+		for _, option := range poll.GetOptions() {
+			options = append(options, option.GetOptionName())
+		}
+		forwarded = inst.Client.BuildPollCreation(poll.GetName(), options, int(poll.GetSelectableOptionsCount()))
+	} else if text := content.GetConversation(); text != "" {
+		// Plain text can't carry a ContextInfo
+		forwarded = &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{Text: proto.String(text)}}
+	} else {
+		forwarded = proto.Clone(content).(*waE2E.Message)
+		forwarded.MessageContextInfo = nil
+	}
+
+	ctx := EnsureContextInfo(forwarded)
+	if ctx == nil {
+		return nil, fmt.Errorf("%w: unsupported message type", ErrNotForwardable)
+	}
+	// A forward doesn't keep the original's quote or mentions
+	*ctx = waE2E.ContextInfo{
+		IsForwarded:     proto.Bool(true),
+		ForwardingScore: proto.Uint32(previous.GetForwardingScore() + 1),
+	}
+	return forwarded, nil
+}
+
+// unwrapForwardContent strips the wrappers a message can arrive in down to its content
+func unwrapForwardContent(msg *waE2E.Message) *waE2E.Message {
+	for msg != nil {
+		switch {
+		case msg.GetDeviceSentMessage().GetMessage() != nil:
+			msg = msg.GetDeviceSentMessage().GetMessage()
+		case msg.GetEphemeralMessage().GetMessage() != nil:
+			msg = msg.GetEphemeralMessage().GetMessage()
+		case msg.GetDocumentWithCaptionMessage().GetMessage() != nil:
+			msg = msg.GetDocumentWithCaptionMessage().GetMessage()
+		default:
+			return msg
+		}
+	}
+	return nil
+}
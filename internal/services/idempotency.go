@@ -0,0 +1,185 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/platform/database"
+	"multi-client-whatsapp/internal/types"
+)
+
+// Idempotency key limits
+const (
+	maxIdempotencyKeyLength  = 255
+	idempotencyCleanupPeriod = 10 * time.Minute
+)
+
+var (
+	ErrIdempotencyKeyMismatch   = errors.New("idempotency key was already used with a different request body")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+// idempotencyTTL is how long responses are kept for replays, IDEMPOTENCY_TTL overrides it
+var idempotencyTTL = 24 * time.Hour
+
+var (
+	lastIdempotencyCleanup time.Time
+	idempotencyMutex       sync.Mutex
+)
+
+func init() {
+	if value := os.Getenv("IDEMPOTENCY_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			idempotencyTTL = ttl
+		} else {
+			log.Printf("Invalid IDEMPOTENCY_TTL %q, using %s", value, idempotencyTTL)
+		}
+	}
+}
+
+// ValidateIdempotencyKey checks the length of a client supplied key
+func ValidateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+	return nil
+}
+
+// IdempotencyRequestHash hashes a request body independently of key order
+// and whitespace, so only a real change of the request counts as different
+func IdempotencyRequestHash(body map[string]json.RawMessage) (string, error) {
+	canonical := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return "", err
+		}
+		canonical[key] = decoded
+	}
+	// encoding/json sorts map keys, which makes the encoding canonical
+	encoded, err := json.Marshal(canonical)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ReserveIdempotencyKey claims a key for a new request. When the key was
+// already used, the stored record is returned instead: with its response if
+// the first request completed, or ErrIdempotencyKeyInProgress /
+// ErrIdempotencyKeyMismatch.
+func ReserveIdempotencyKey(instanceKey, key, requestHash string) (*types.IdempotencyRecord, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+	cleanupIdempotencyKeys(db)
+
+	// An expired key can be reused
+	if _, err := db.Exec(`DELETE FROM idempotency_keys WHERE instance_key = $1 AND key = $2 AND expires_at < now()`, instanceKey, key); err != nil {
+		return nil, fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+
+	result, err := db.Exec(`INSERT INTO idempotency_keys (instance_key, key, request_hash, status, created_at, expires_at)
+		VALUES ($1, $2, $3, 'processing', now(), $4)
+		ON CONFLICT (instance_key, key) DO NOTHING`,
+		instanceKey, key, requestHash, time.Now().Add(idempotencyTTL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 1 {
+		return nil, nil
+	}
+
+	record := &types.IdempotencyRecord{InstanceKey: instanceKey, Key: key}
+	var responseCode sql.NullInt64
+	var response []byte
+	err = db.QueryRow(`SELECT request_hash, status, response_code, response, created_at, expires_at
+		FROM idempotency_keys WHERE instance_key = $1 AND key = $2`, instanceKey, key).
+		Scan(&record.RequestHash, &record.Status, &responseCode, &response, &record.CreatedAt, &record.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released by a failed first request right after our insert attempt
+		return ReserveIdempotencyKey(instanceKey, key, requestHash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load idempotency key: %w", err)
+	}
+	record.ResponseCode = int(responseCode.Int64)
+	record.Response = response
+
+	switch {
+	case record.RequestHash != requestHash:
+		return nil, ErrIdempotencyKeyMismatch
+	case record.Status != "completed":
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return record, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request that reserved the key
+func CompleteIdempotencyKey(instanceKey, key string, code int, response []byte) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		log.Printf("Warning: Error storing response for idempotency key %s: %v", key, err)
+		return
+	}
+
+	var stored interface{}
+	if json.Valid(response) {
+		stored = string(response)
+	}
+	_, err = db.Exec(`UPDATE idempotency_keys SET status = 'completed', response_code = $3, response = $4
+		WHERE instance_key = $1 AND key = $2`, instanceKey, key, code, stored)
+	if err != nil {
+		log.Printf("Warning: Error storing response for idempotency key %s: %v", key, err)
+	}
+}
+
+// ReleaseIdempotencyKey forgets a key whose request was rejected or failed before
+// sending, so the client can retry it
+func ReleaseIdempotencyKey(instanceKey, key string) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		log.Printf("Warning: Error releasing idempotency key %s: %v", key, err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM idempotency_keys WHERE instance_key = $1 AND key = $2`, instanceKey, key); err != nil {
+		log.Printf("Warning: Error releasing idempotency key %s: %v", key, err)
+	}
+}
+
+// DeleteIdempotencyKeys removes all keys of a deleted instance
+func DeleteIdempotencyKeys(instanceKey string) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		log.Printf("Warning: Error deleting idempotency keys of instance %s: %v", instanceKey, err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM idempotency_keys WHERE instance_key = $1`, instanceKey); err != nil {
+		log.Printf("Warning: Error deleting idempotency keys of instance %s: %v", instanceKey, err)
+	}
+}
+
+// cleanupIdempotencyKeys drops expired keys every now and then
+func cleanupIdempotencyKeys(db *sql.DB) {
+	idempotencyMutex.Lock()
+	if time.Since(lastIdempotencyCleanup) < idempotencyCleanupPeriod {
+		idempotencyMutex.Unlock()
+		return
+	}
+	lastIdempotencyCleanup = time.Now()
+	idempotencyMutex.Unlock()
+
+	if _, err := db.Exec(`DELETE FROM idempotency_keys WHERE expires_at < now()`); err != nil {
+		log.Printf("Warning: Error deleting expired idempotency keys: %v", err)
+	}
+}
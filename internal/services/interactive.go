@@ -0,0 +1,272 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// Limits enforced by WhatsApp clients for interactive messages
+const (
+	maxReplyButtons      = 3
+	maxNativeFlowButtons = 10
+	maxListRows          = 10
+)
+
+// BuildInteractiveMessage builds a buttons, list or native flow message from
+// the request. The content is wrapped in a view-once envelope with device list
+// metadata, which is what current phone clients need to render it.
+func BuildInteractiveMessage(req *types.InteractiveMessageRequest) (*waE2E.Message, error) {
+	var inner *waE2E.Message
+	var err error
+
+	switch req.Type {
+	case "", "buttons":
+		inner, err = buildButtonsMessage(req)
+	case "list":
+		inner, err = buildListMessage(req)
+	case "native_flow":
+		inner, err = buildNativeFlowMessage(req)
+	default:
+		return nil, fmt.Errorf("invalid interactive message type: %s", req.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	inner.MessageContextInfo = &waE2E.MessageContextInfo{
+		DeviceListMetadata:        &waE2E.DeviceListMetadata{},
+		DeviceListMetadataVersion: proto.Int32(2),
+	}
+	return &waE2E.Message{
+		ViewOnceMessage: &waE2E.FutureProofMessage{Message: inner},
+	}, nil
+}
+
+// buildButtonsMessage builds a message with up to three quick reply buttons
+func buildButtonsMessage(req *types.InteractiveMessageRequest) (*waE2E.Message, error) {
+	if len(req.Buttons) == 0 {
+		return nil, fmt.Errorf("at least one button is required")
+	}
+	if len(req.Buttons) > maxReplyButtons {
+		return nil, fmt.Errorf("maximum %d buttons allowed", maxReplyButtons)
+	}
+
+	buttons := make([]*waE2E.ButtonsMessage_Button, 0, len(req.Buttons))
+	for _, button := range req.Buttons {
+		if button.Type != "" && button.Type != "reply" {
+			return nil, fmt.Errorf("button %s: only reply buttons are supported in buttons messages, use native_flow for %s buttons", button.ID, button.Type)
+		}
+		if button.ID == "" || button.Title == "" {
+			return nil, fmt.Errorf("every button needs an id and a title")
+		}
+		buttons = append(buttons, &waE2E.ButtonsMessage_Button{
+			ButtonID:   proto.String(button.ID),
+			ButtonText: &waE2E.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(button.Title)},
+			Type:       waE2E.ButtonsMessage_Button_RESPONSE.Enum(),
+		})
+	}
+
+	msg := &waE2E.ButtonsMessage{
+		ContentText: proto.String(req.Body),
+		Buttons:     buttons,
+		HeaderType:  waE2E.ButtonsMessage_EMPTY.Enum(),
+	}
+	if req.Title != "" {
+		msg.Header = &waE2E.ButtonsMessage_Text{Text: req.Title}
+		msg.HeaderType = waE2E.ButtonsMessage_TEXT.Enum()
+	}
+	if req.Footer != "" {
+		msg.FooterText = proto.String(req.Footer)
+	}
+	return &waE2E.Message{ButtonsMessage: msg}, nil
+}
+
+// buildListMessage builds a single select list message
+func buildListMessage(req *types.InteractiveMessageRequest) (*waE2E.Message, error) {
+	if req.ButtonText == "" {
+		return nil, fmt.Errorf("button_text is required for list messages")
+	}
+	if len(req.Sections) == 0 {
+		return nil, fmt.Errorf("at least one section is required for list messages")
+	}
+
+	totalRows := 0
+	sections := make([]*waE2E.ListMessage_Section, 0, len(req.Sections))
+	for _, section := range req.Sections {
+		if len(section.Rows) == 0 {
+			return nil, fmt.Errorf("section %q has no rows", section.Title)
+		}
+		rows := make([]*waE2E.ListMessage_Row, 0, len(section.Rows))
+		for _, row := range section.Rows {
+			if row.ID == "" || row.Title == "" {
+				return nil, fmt.Errorf("every row needs an id and a title")
+			}
+			listRow := &waE2E.ListMessage_Row{
+				RowID: proto.String(row.ID),
+				Title: proto.String(row.Title),
+			}
+			if row.Description != "" {
+				listRow.Description = proto.String(row.Description)
+			}
+			rows = append(rows, listRow)
+		}
+		totalRows += len(rows)
+		sections = append(sections, &waE2E.ListMessage_Section{
+			Title: proto.String(section.Title),
+			Rows:  rows,
+		})
+	}
+	if totalRows > maxListRows {
+		return nil, fmt.Errorf("maximum %d rows allowed across all sections", maxListRows)
+	}
+
+	msg := &waE2E.ListMessage{
+		Title:       proto.String(req.Title),
+		Description: proto.String(req.Body),
+		ButtonText:  proto.String(req.ButtonText),
+		ListType:    waE2E.ListMessage_SINGLE_SELECT.Enum(),
+		Sections:    sections,
+	}
+	if req.Footer != "" {
+		msg.FooterText = proto.String(req.Footer)
+	}
+	return &waE2E.Message{ListMessage: msg}, nil
+}
+
+// buildNativeFlowMessage builds an interactive message with native flow
+// buttons, which support quick replies as well as URL, call and copy buttons
+func buildNativeFlowMessage(req *types.InteractiveMessageRequest) (*waE2E.Message, error) {
+	if len(req.Buttons) == 0 {
+		return nil, fmt.Errorf("at least one button is required")
+	}
+	if len(req.Buttons) > maxNativeFlowButtons {
+		return nil, fmt.Errorf("maximum %d buttons allowed", maxNativeFlowButtons)
+	}
+
+	buttons := make([]*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton, 0, len(req.Buttons))
+	for _, button := range req.Buttons {
+		if button.Title == "" {
+			return nil, fmt.Errorf("every button needs a title")
+		}
+
+		var name string
+		params := map[string]string{"display_text": button.Title}
+		switch button.Type {
+		case "", "reply":
+			if button.ID == "" {
+				return nil, fmt.Errorf("reply button %q needs an id", button.Title)
+			}
+			name = "quick_reply"
+			params["id"] = button.ID
+		case "url":
+			if button.URL == "" {
+				return nil, fmt.Errorf("url button %q needs a url", button.Title)
+			}
+			name = "cta_url"
+			params["url"] = button.URL
+			params["merchant_url"] = button.URL
+		case "call":
+			if button.PhoneNumber == "" {
+				return nil, fmt.Errorf("call button %q needs a phone_number", button.Title)
+			}
+			name = "cta_call"
+			params["phone_number"] = button.PhoneNumber
+		case "copy":
+			if button.CopyCode == "" {
+				return nil, fmt.Errorf("copy button %q needs a copy_code", button.Title)
+			}
+			name = "cta_copy"
+			params["copy_code"] = button.CopyCode
+		default:
+			return nil, fmt.Errorf("invalid button type: %s", button.Type)
+		}
+		if button.ID != "" {
+			params["id"] = button.ID
+		}
+
+		paramsJSON, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode button params: %v", err)
+		}
+		buttons = append(buttons, &waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton{
+			Name:             proto.String(name),
+			ButtonParamsJSON: proto.String(string(paramsJSON)),
+		})
+	}
+
+	msg := &waE2E.InteractiveMessage{
+		Body: &waE2E.InteractiveMessage_Body{Text: proto.String(req.Body)},
+		InteractiveMessage: &waE2E.InteractiveMessage_NativeFlowMessage_{
+			NativeFlowMessage: &waE2E.InteractiveMessage_NativeFlowMessage{
+				Buttons:           buttons,
+				MessageParamsJSON: proto.String("{}"),
+				MessageVersion:    proto.Int32(1),
+			},
+		},
+	}
+	if req.Title != "" {
+		msg.Header = &waE2E.InteractiveMessage_Header{
+			Title:              proto.String(req.Title),
+			HasMediaAttachment: proto.Bool(false),
+		}
+	}
+	if req.Footer != "" {
+		msg.Footer = &waE2E.InteractiveMessage_Footer{Text: proto.String(req.Footer)}
+	}
+	return &waE2E.Message{InteractiveMessage: msg}, nil
+}
+
+// ParseInteractiveReply extracts the tapped button or list row from an
+// inbound message. Returns nil if the message isn't a reply to an
+// interactive message.
+func ParseInteractiveReply(msg *waE2E.Message) *types.InteractiveReply {
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		resp := msg.GetButtonsResponseMessage()
+		return &types.InteractiveReply{
+			Type:            "button_reply",
+			ID:              resp.GetSelectedButtonID(),
+			Title:           resp.GetSelectedDisplayText(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		resp := msg.GetTemplateButtonReplyMessage()
+		return &types.InteractiveReply{
+			Type:            "button_reply",
+			ID:              resp.GetSelectedID(),
+			Title:           resp.GetSelectedDisplayText(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+	case msg.GetListResponseMessage() != nil:
+		resp := msg.GetListResponseMessage()
+		return &types.InteractiveReply{
+			Type:            "list_reply",
+			ID:              resp.GetSingleSelectReply().GetSelectedRowID(),
+			Title:           resp.GetTitle(),
+			Description:     resp.GetDescription(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+	case msg.GetInteractiveResponseMessage() != nil:
+		resp := msg.GetInteractiveResponseMessage()
+		reply := &types.InteractiveReply{
+			Type:            "button_reply",
+			Title:           resp.GetBody().GetText(),
+			QuotedMessageID: resp.GetContextInfo().GetStanzaID(),
+		}
+		if flow := resp.GetNativeFlowResponseMessage(); flow != nil {
+			var params struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal([]byte(flow.GetParamsJSON()), &params); err == nil {
+				reply.ID = params.ID
+			}
+		}
+		return reply
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register GIF decoder for thumbnails
+	"image/jpeg"
+	_ "image/png" // Register PNG decoder for thumbnails
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"golang.org/x/net/html"
+	"google.golang.org/protobuf/proto"
+)
+
+// Link preview limits, so a slow or huge page can't hold up a send
+const (
+	previewFetchTimeout  = 5 * time.Second
+	maxPreviewPageSize   = 512 * 1024
+	maxPreviewImageSize  = 5 * 1024 * 1024
+	previewThumbnailSize = 300
+	previewUserAgent     = "Mozilla/5.0 (compatible; WhatsAppBridge/1.0; +link-preview)"
+	maxPreviewRedirects  = 5
+
+	// A small compressed image can still decode to a huge bitmap
+	maxThumbnailSourcePixels = 25_000_000
+)
+
+// urlRegex matches the first http(s) URL in a message
+var urlRegex = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// errPreviewAddressBlocked is returned for previews and thumbnails on
+// loopback, private and link-local addresses
+var errPreviewAddressBlocked = errors.New("address is not allowed")
+
+// previewHTTPClient fetches pages and images for previews and thumbnails.
+// URLs come from message text and API callers, so every connection, redirects
+// included, is checked against the resolved address to keep them from
+// reaching the bridge's own network.
+var previewHTTPClient = &http.Client{
+	Timeout: previewFetchTimeout,
+	Transport: &http.Transport{
+		// No proxy, the dial check has to see the address actually fetched
+		DialContext: (&net.Dialer{
+			Timeout: previewFetchTimeout,
+			Control: previewDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: previewFetchTimeout,
+	},
+	CheckRedirect: checkPreviewRedirect,
+}
+
+// previewAddressAllowed decides which addresses previews may connect to.
+// Tests replace it to reach their local servers.
+var previewAddressAllowed = func(address netip.AddrPort) bool {
+	return publicAddress(address.Addr())
+}
+
+// publicAddress reports whether an IP is publicly routable, rejecting
+// loopback, private, link-local, multicast and unspecified addresses
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast()
+}
+
+// previewDialControl runs after DNS resolution, right before connecting, so a
+// host name can't resolve to a blocked address
+func previewDialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPreviewAddressBlocked, address)
+	}
+	if !previewAddressAllowed(addrPort) {
+		return fmt.Errorf("%w: %s", errPreviewAddressBlocked, addrPort.Addr())
+	}
+	return nil
+}
+
+// checkPreviewRedirect limits redirects to http(s) and rejects redirects to
+// blocked IP addresses up front. Host names are checked when dialing.
+func checkPreviewRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxPreviewRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxPreviewRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+	ip, err := netip.ParseAddr(strings.Trim(req.URL.Hostname(), "[]"))
+	if err != nil {
+		return nil
+	}
+	port := 80
+	if req.URL.Scheme == "https" {
+		port = 443
+	}
+	if p, err := strconv.Atoi(req.URL.Port()); err == nil {
+		port = p
+	}
+	if !previewAddressAllowed(netip.AddrPortFrom(ip, uint16(port))) {
+		return fmt.Errorf("redirect to %s: %w", ip, errPreviewAddressBlocked)
+	}
+	return nil
+}
+
+// FindFirstURL returns the first http(s) URL in the text, or "" if there is none
+func FindFirstURL(text string) string {
+	return strings.TrimRight(urlRegex.FindString(text), ".,;:!?)]}'")
+}
+
+// BuildLinkPreview fills in the link preview fields of a text message. When
+// custom preview data is given it's used as-is (fetching only the thumbnail
+// URL if needed), otherwise the first URL in the text is fetched and its
+// OpenGraph/Twitter meta tags are used.
+func BuildLinkPreview(text string, custom *types.LinkPreview) (*types.LinkPreview, []byte, error) {
+	preview := &types.LinkPreview{}
+	if custom != nil {
+		*preview = *custom
+	}
+	if preview.URL == "" {
+		preview.URL = FindFirstURL(text)
+	}
+	if preview.URL == "" {
+		return nil, nil, fmt.Errorf("no URL found in message")
+	}
+
+	if custom == nil {
+		if err := fetchPreviewMetadata(preview); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// A broken image only costs the preview its thumbnail
+	thumbnail, err := previewThumbnail(preview)
+	if err != nil {
+		log.Printf("Link preview for %s without thumbnail: %v", preview.URL, err)
+	}
+	return preview, thumbnail, nil
+}
+
+// previewThumbnail makes the thumbnail of a preview from its base64 image or
+// image URL, nil if it has neither
+func previewThumbnail(preview *types.LinkPreview) ([]byte, error) {
+	var data []byte
+	var err error
+	switch {
+	case preview.Thumbnail != "":
+		data, err = base64.StdEncoding.DecodeString(preview.Thumbnail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thumbnail: %v", err)
+		}
+	case preview.ThumbnailURL != "":
+		data, err = fetchLimited(preview.ThumbnailURL, maxPreviewImageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch thumbnail: %v", err)
+		}
+	default:
+		return nil, nil
+	}
+	return makeThumbnail(data)
+}
+
+// ApplyLinkPreview sets the preview fields on an extended text message
+func ApplyLinkPreview(msg *waE2E.ExtendedTextMessage, preview *types.LinkPreview, thumbnail []byte) {
+	msg.MatchedText = proto.String(preview.URL)
+	msg.Title = proto.String(preview.Title)
+	msg.Description = proto.String(preview.Description)
+	msg.PreviewType = waE2E.ExtendedTextMessage_NONE.Enum()
+	if len(thumbnail) > 0 {
+		msg.JPEGThumbnail = thumbnail
+	}
+}
+
+// fetchPreviewMetadata fetches the page and fills title, description and image from its meta tags
+func fetchPreviewMetadata(preview *types.LinkPreview) error {
+	req, err := http.NewRequest(http.MethodGet, preview.URL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	req.Header.Set("User-Agent", previewUserAgent)
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := previewHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch URL: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch URL: %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "html") {
+		return fmt.Errorf("URL is not an HTML page: %s", contentType)
+	}
+
+	meta := parseMetaTags(io.LimitReader(resp.Body, maxPreviewPageSize))
+
+	preview.Title = firstNonEmpty(meta["og:title"], meta["twitter:title"], meta["title"])
+	preview.Description = firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"])
+	if image := firstNonEmpty(meta["og:image"], meta["og:image:url"], meta["twitter:image"], meta["twitter:image:src"]); image != "" {
+		// Image URLs may be relative to the page
+		if base, err := url.Parse(preview.URL); err == nil {
+			if ref, err := base.Parse(image); err == nil {
+				image = ref.String()
+			}
+		}
+		preview.ThumbnailURL = image
+	}
+	return nil
+}
+
+// parseMetaTags collects <meta> property/name values and the <title> of an HTML page
+func parseMetaTags(r io.Reader) map[string]string {
+	meta := make(map[string]string)
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return meta
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "meta":
+				var key, content string
+				for _, attr := range token.Attr {
+					switch strings.ToLower(attr.Key) {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				if key != "" && content != "" && meta[key] == "" {
+					meta[key] = content
+				}
+			case "title":
+				inTitle = true
+			}
+		case html.TextToken:
+			if inTitle && meta["title"] == "" {
+				meta["title"] = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				// Everything we need lives in <head>
+				return meta
+			}
+		}
+	}
+}
+
+// fetchLimited downloads a URL, failing if the body is larger than maxSize
+func fetchLimited(rawURL string, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", previewUserAgent)
+
+	resp, err := previewHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// makeThumbnail decodes an image and re-encodes it as a small JPEG
+func makeThumbnail(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode thumbnail image: %v", err)
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, fmt.Errorf("thumbnail image is too large: %dx%d", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode thumbnail image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(img, previewThumbnailSize), &jpeg.Options{Quality: 75}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleImage shrinks an image so its longest side is at most maxSide, using
+// nearest-neighbour sampling which is good enough for a preview thumbnail
+func scaleImage(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return img
+	}
+
+	newWidth, newHeight := maxSide, maxSide
+	if width > height {
+		newHeight = height * maxSide / width
+	} else {
+		newWidth = width * maxSide / height
+	}
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			scaled.Set(x, y, img.At(bounds.Min.X+x*width/newWidth, bounds.Min.Y+y*height/newHeight))
+		}
+	}
+	return scaled
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
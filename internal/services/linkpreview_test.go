@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"multi-client-whatsapp/internal/types"
+)
+
+func TestParseMetaTags(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want map[string]string
+	}{
+		{
+			name: "OpenGraph and Twitter tags",
+			page: `<html><head>
+				<meta property="og:title" content="OG Title">
+				<meta property="og:description" content=" OG description ">
+				<meta name="twitter:image" content="/banner.png">
+				</head></html>`,
+			want: map[string]string{
+				"og:title":       "OG Title",
+				"og:description": "OG description",
+				"twitter:image":  "/banner.png",
+			},
+		},
+		{
+			name: "title element",
+			page: `<html><head><title> Page Title </title></head></html>`,
+			want: map[string]string{"title": "Page Title"},
+		},
+		{
+			name: "first value wins and keys are case insensitive",
+			page: `<head>
+				<META PROPERTY="OG:Title" CONTENT="First">
+				<meta property="og:title" content="Second">
+				</head>`,
+			want: map[string]string{"og:title": "First"},
+		},
+		{
+			name: "self closing and empty tags",
+			page: `<head><meta name="description" content="Closed"/><meta name="keywords" content=""></head>`,
+			want: map[string]string{"description": "Closed"},
+		},
+		{
+			name: "body is ignored",
+			page: `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			want: map[string]string{"title": "Head"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseMetaTags(strings.NewReader(test.page))
+			if len(got) != len(test.want) {
+				t.Fatalf("parseMetaTags() = %v, want %v", got, test.want)
+			}
+			for key, value := range test.want {
+				if got[key] != value {
+					t.Errorf("parseMetaTags()[%q] = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+// encodePNG returns a width x height PNG
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// hugePNG returns a small PNG whose header claims width x height pixels, a
+// decompression bomb as far as DecodeConfig can tell
+func hugePNG(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	data := encodePNG(t, 1, 1)
+	// The IHDR chunk follows the 8 byte signature: length, type, width, height, ..., CRC
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+// allowPreviewAddresses lets previews reach local test servers, the addresses
+// previewHTTPClient otherwise rejects
+func allowPreviewAddresses(t *testing.T, allowed func(netip.AddrPort) bool) {
+	t.Helper()
+	previous := previewAddressAllowed
+	previewAddressAllowed = allowed
+	t.Cleanup(func() { previewAddressAllowed = previous })
+}
+
+// previewServer serves a page whose preview image is at imagePath
+func previewServer(t *testing.T, imagePath string, images map[string][]byte) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head>
+			<title>Fallback</title>
+			<meta property="og:title" content="Article">
+			<meta name="description" content="About the article">
+			<meta property="og:image" content="` + imagePath + `">
+			</head><body>Text</body></html>`))
+	})
+	for path, data := range images {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	allowPreviewAddresses(t, func(netip.AddrPort) bool { return true })
+	return server
+}
+
+func TestBuildLinkPreview(t *testing.T) {
+	server := previewServer(t, "/banner.png", map[string][]byte{"/banner.png": encodePNG(t, 600, 300)})
+
+	preview, thumbnail, err := BuildLinkPreview("Read this: "+server.URL+"/article.", nil)
+	if err != nil {
+		t.Fatalf("BuildLinkPreview() error = %v", err)
+	}
+	if preview.URL != server.URL+"/article" {
+		t.Errorf("URL = %q, want %q", preview.URL, server.URL+"/article")
+	}
+	if preview.Title != "Article" || preview.Description != "About the article" {
+		t.Errorf("title, description = %q, %q", preview.Title, preview.Description)
+	}
+	// The relative image URL is resolved against the page
+	if preview.ThumbnailURL != server.URL+"/banner.png" {
+		t.Errorf("ThumbnailURL = %q, want %q", preview.ThumbnailURL, server.URL+"/banner.png")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatalf("thumbnail isn't a JPEG: %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != previewThumbnailSize || bounds.Dy() != previewThumbnailSize/2 {
+		t.Errorf("thumbnail is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), previewThumbnailSize, previewThumbnailSize/2)
+	}
+}
+
+func TestBuildLinkPreviewThumbnailFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		imagePath string
+		images    map[string][]byte
+	}{
+		{name: "missing image", imagePath: "/missing.png"},
+		{name: "not an image", imagePath: "/banner.png", images: map[string][]byte{"/banner.png": []byte("<html></html>")}},
+		{name: "too large", imagePath: "/banner.png", images: map[string][]byte{"/banner.png": hugePNG(t, 60000, 60000)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := previewServer(t, test.imagePath, test.images)
+
+			preview, thumbnail, err := BuildLinkPreview(server.URL+"/article", nil)
+			if err != nil {
+				t.Fatalf("BuildLinkPreview() error = %v, want the preview without thumbnail", err)
+			}
+			if preview.Title != "Article" {
+				t.Errorf("Title = %q, want %q", preview.Title, "Article")
+			}
+			if thumbnail != nil {
+				t.Errorf("thumbnail = %d bytes, want none", len(thumbnail))
+			}
+		})
+	}
+}
+
+func TestBuildLinkPreviewCustom(t *testing.T) {
+	custom := &types.LinkPreview{
+		Title:     "Custom",
+		Thumbnail: base64.StdEncoding.EncodeToString(encodePNG(t, 40, 20)),
+	}
+
+	// Custom previews don't fetch the page, the URL doesn't need to exist
+	preview, thumbnail, err := BuildLinkPreview("See https://example.invalid/page", custom)
+	if err != nil {
+		t.Fatalf("BuildLinkPreview() error = %v", err)
+	}
+	if preview.URL != "https://example.invalid/page" || preview.Title != "Custom" {
+		t.Errorf("preview = %+v", preview)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(thumbnail)); err != nil {
+		t.Errorf("thumbnail isn't a JPEG: %v", err)
+	}
+
+	if _, _, err := BuildLinkPreview("no link here", nil); err == nil {
+		t.Error("BuildLinkPreview() without a URL succeeded")
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+		"224.0.0.1":       false,
+	}
+	for address, want := range tests {
+		if got := publicAddress(netip.MustParseAddr(address)); got != want {
+			t.Errorf("publicAddress(%s) = %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestPreviewBlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encodePNG(t, 10, 10))
+	}))
+	t.Cleanup(server.Close)
+
+	if _, err := fetchLimited(server.URL, maxPreviewImageSize); !errors.Is(err, errPreviewAddressBlocked) {
+		t.Errorf("fetchLimited(%s) error = %v, want %v", server.URL, err, errPreviewAddressBlocked)
+	}
+	if _, err := fetchLimited(strings.Replace(server.URL, "127.0.0.1", "localhost", 1), maxPreviewImageSize); !errors.Is(err, errPreviewAddressBlocked) {
+		t.Errorf("fetchLimited() through a host name error = %v, want %v", err, errPreviewAddressBlocked)
+	}
+}
+
+func TestPreviewBlocksRedirectsToPrivateAddresses(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encodePNG(t, 10, 10))
+	}))
+	t.Cleanup(internal.Close)
+	public := httptest.NewServer(http.RedirectHandler(internal.URL+"/secret.png", http.StatusFound))
+	t.Cleanup(public.Close)
+
+	// Only the redirecting server counts as public
+	publicAddr := netip.MustParseAddrPort(public.Listener.Addr().String())
+	allowPreviewAddresses(t, func(address netip.AddrPort) bool { return address == publicAddr })
+
+	if _, err := fetchLimited(public.URL, maxPreviewImageSize); !errors.Is(err, errPreviewAddressBlocked) {
+		t.Errorf("fetchLimited() following a redirect error = %v, want %v", err, errPreviewAddressBlocked)
+	}
+}
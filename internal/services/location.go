@@ -0,0 +1,203 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// Live location durations offered by the WhatsApp apps are 15 minutes, 1 hour and 8 hours
+const (
+	defaultLiveLocationDuration = 15 * time.Minute
+	maxLiveLocationDuration     = 8 * time.Hour
+)
+
+// liveLocationSession tracks a live location share so updates can be sent with
+// increasing sequence numbers until it expires
+type liveLocationSession struct {
+	Chat      whatsappTypes.JID
+	Sequence  int64
+	StartedAt time.Time
+	ExpiresAt time.Time
+}
+
+var (
+	// liveLocations maps instance key -> live location message ID -> session
+	liveLocations      = make(map[string]map[string]*liveLocationSession)
+	liveLocationsMutex sync.Mutex
+)
+
+// LocationThumbnail returns the JPEG thumbnail for a location message from
+// either base64 data or a URL. Returns nil if neither is set. An image URL that
+// can't be fetched or decoded only drops the thumbnail, the location is still
+// worth sending.
+func LocationThumbnail(thumbnail, thumbnailURL string) ([]byte, error) {
+	switch {
+	case thumbnail != "":
+		data, err := base64.StdEncoding.DecodeString(thumbnail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thumbnail: %v", err)
+		}
+		return makeThumbnail(data)
+	case thumbnailURL != "":
+		data, err := fetchLimited(thumbnailURL, maxPreviewImageSize)
+		if err == nil {
+			var jpeg []byte
+			if jpeg, err = makeThumbnail(data); err == nil {
+				return jpeg, nil
+			}
+		}
+		log.Printf("Location without thumbnail from %s: %v", thumbnailURL, err)
+		return nil, nil
+	default:
+		return nil, nil
+	}
+}
+
+// LiveLocationDuration validates the requested share duration in seconds
+func LiveLocationDuration(seconds int) (time.Duration, error) {
+	if seconds == 0 {
+		return defaultLiveLocationDuration, nil
+	}
+	duration := time.Duration(seconds) * time.Second
+	if seconds < 0 || duration > maxLiveLocationDuration {
+		return 0, fmt.Errorf("live_duration must be between 1 and %d seconds", int(maxLiveLocationDuration.Seconds()))
+	}
+	return duration, nil
+}
+
+// StartLiveLocation remembers a sent live location message so it can be updated
+func StartLiveLocation(instanceKey, messageID string, chat whatsappTypes.JID, duration time.Duration) time.Time {
+	now := time.Now()
+	session := &liveLocationSession{
+		Chat:      chat,
+		Sequence:  1,
+		StartedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+
+	liveLocationsMutex.Lock()
+	defer liveLocationsMutex.Unlock()
+
+	sessions, ok := liveLocations[instanceKey]
+	if !ok {
+		sessions = make(map[string]*liveLocationSession)
+		liveLocations[instanceKey] = sessions
+	}
+	// Drop expired shares while we're here
+	for id, existing := range sessions {
+		if now.After(existing.ExpiresAt) {
+			delete(sessions, id)
+		}
+	}
+	sessions[messageID] = session
+	return session.ExpiresAt
+}
+
+// NextLiveLocationUpdate builds the next update for a live location share,
+// bumping its sequence number. Returns the chat to send it to.
+func NextLiveLocationUpdate(instanceKey string, req *types.LiveLocationUpdateRequest) (whatsappTypes.JID, *waE2E.Message, int64, error) {
+	liveLocationsMutex.Lock()
+	defer liveLocationsMutex.Unlock()
+
+	session, ok := liveLocations[instanceKey][req.MessageID]
+	if !ok {
+		return whatsappTypes.JID{}, nil, 0, fmt.Errorf("live location %s not found", req.MessageID)
+	}
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		delete(liveLocations[instanceKey], req.MessageID)
+		return whatsappTypes.JID{}, nil, 0, fmt.Errorf("live location %s expired at %s", req.MessageID, session.ExpiresAt.Format(time.RFC3339))
+	}
+
+	session.Sequence++
+	msg := &waE2E.Message{
+		LiveLocationMessage: &waE2E.LiveLocationMessage{
+			DegreesLatitude:  proto.Float64(req.Latitude),
+			DegreesLongitude: proto.Float64(req.Longitude),
+			SequenceNumber:   proto.Int64(session.Sequence),
+			TimeOffset:       proto.Uint32(uint32(now.Sub(session.StartedAt).Seconds())),
+		},
+	}
+	applyLiveLocationExtras(msg.LiveLocationMessage, req.AccuracyInMeters, req.SpeedInMps, req.Heading, req.Caption)
+	return session.Chat, msg, session.Sequence, nil
+}
+
+// ForgetLiveLocations drops all live location shares for an instance
+func ForgetLiveLocations(instanceKey string) {
+	liveLocationsMutex.Lock()
+	delete(liveLocations, instanceKey)
+	liveLocationsMutex.Unlock()
+}
+
+// BuildLiveLocationMessage builds the first message of a live location share
+func BuildLiveLocationMessage(req *types.LocationMessageRequest, thumbnail []byte) *waE2E.Message {
+	msg := &waE2E.Message{
+		LiveLocationMessage: &waE2E.LiveLocationMessage{
+			DegreesLatitude:  proto.Float64(req.Latitude),
+			DegreesLongitude: proto.Float64(req.Longitude),
+			SequenceNumber:   proto.Int64(1),
+			TimeOffset:       proto.Uint32(0),
+			JPEGThumbnail:    thumbnail,
+		},
+	}
+	applyLiveLocationExtras(msg.LiveLocationMessage, req.AccuracyInMeters, 0, 0, req.Caption)
+	return msg
+}
+
+// applyLiveLocationExtras sets the optional fields of a live location message
+func applyLiveLocationExtras(msg *waE2E.LiveLocationMessage, accuracy uint32, speed float32, heading uint32, caption string) {
+	if accuracy > 0 {
+		msg.AccuracyInMeters = proto.Uint32(accuracy)
+	}
+	if speed > 0 {
+		msg.SpeedInMps = proto.Float32(speed)
+	}
+	if heading > 0 {
+		msg.DegreesClockwiseFromMagneticNorth = proto.Uint32(heading)
+	}
+	if caption != "" {
+		msg.Caption = proto.String(caption)
+	}
+}
+
+// ParseLocation extracts the coordinates and labels of an inbound location or
+// live location message. Returns nil for other messages.
+func ParseLocation(msg *waE2E.Message) *types.LocationData {
+	if loc := msg.GetLocationMessage(); loc != nil {
+		return &types.LocationData{
+			Latitude:         loc.GetDegreesLatitude(),
+			Longitude:        loc.GetDegreesLongitude(),
+			Name:             loc.GetName(),
+			Address:          loc.GetAddress(),
+			URL:              loc.GetURL(),
+			Comment:          loc.GetComment(),
+			AccuracyInMeters: loc.GetAccuracyInMeters(),
+			SpeedInMps:       loc.GetSpeedInMps(),
+			Heading:          loc.GetDegreesClockwiseFromMagneticNorth(),
+			IsLive:           loc.GetIsLive(),
+		}
+	}
+	if live := msg.GetLiveLocationMessage(); live != nil {
+		return &types.LocationData{
+			Latitude:         live.GetDegreesLatitude(),
+			Longitude:        live.GetDegreesLongitude(),
+			Caption:          live.GetCaption(),
+			AccuracyInMeters: live.GetAccuracyInMeters(),
+			SpeedInMps:       live.GetSpeedInMps(),
+			Heading:          live.GetDegreesClockwiseFromMagneticNorth(),
+			SequenceNumber:   live.GetSequenceNumber(),
+			TimeOffset:       live.GetTimeOffset(),
+			IsLive:           true,
+		}
+	}
+	return nil
+}
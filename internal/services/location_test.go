@@ -0,0 +1,39 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/jpeg"
+	"testing"
+)
+
+func TestLocationThumbnail(t *testing.T) {
+	server := previewServer(t, "/pin.png", map[string][]byte{
+		"/pin.png":  encodePNG(t, 64, 64),
+		"/page.txt": []byte("not an image"),
+	})
+
+	thumbnail, err := LocationThumbnail("", server.URL+"/pin.png")
+	if err != nil {
+		t.Fatalf("LocationThumbnail() error = %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(thumbnail)); err != nil {
+		t.Errorf("thumbnail isn't a JPEG: %v", err)
+	}
+
+	// An image URL that fails only drops the thumbnail
+	for _, path := range []string{"/missing.png", "/page.txt"} {
+		thumbnail, err := LocationThumbnail("", server.URL+path)
+		if err != nil || thumbnail != nil {
+			t.Errorf("LocationThumbnail(%s) = %d bytes, %v, want no thumbnail and no error", path, len(thumbnail), err)
+		}
+	}
+
+	// Invalid base64 data is the caller's mistake
+	if _, err := LocationThumbnail("not base64!", ""); err == nil {
+		t.Error("LocationThumbnail() with invalid base64 succeeded")
+	}
+	if _, err := LocationThumbnail(base64.StdEncoding.EncodeToString([]byte("text")), ""); err == nil {
+		t.Error("LocationThumbnail() with a non-image succeeded")
+	}
+}
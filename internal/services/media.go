@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxMediaSize caps the media downloaded for a send, it's held in memory
+// while uploading
+const MaxMediaSize = 100 << 20
+
+const mediaDownloadTimeout = 2 * time.Minute
+
+var ErrMediaTooLarge = fmt.Errorf("media is larger than %d MB", MaxMediaSize>>20)
+
+var mediaHTTPClient = &http.Client{Timeout: mediaDownloadTimeout}
+
+// MediaOptions are the fields of a media message that depend on its type
+type MediaOptions struct {
+	Caption  string // Images and videos
+	FileName string // Documents
+	PTT      bool   // Audio recorded as a voice message
+}
+
+// DownloadMedia downloads the media of a send request, at most MaxMediaSize bytes
+func DownloadMedia(mediaURL string) ([]byte, error) {
+	resp, err := mediaHTTPClient.Get(mediaURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download media: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > MaxMediaSize {
+		return nil, ErrMediaTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxMediaSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media data: %v", err)
+	}
+	if len(data) > MaxMediaSize {
+		return nil, ErrMediaTooLarge
+	}
+	return data, nil
+}
+
+// BuildMediaMessage uploads media to WhatsApp for recipient and builds the
+// message of its type: "image", "video", "audio" or "file". The returned
+// handle has to be passed to SendMessage as the MediaHandle.
+func BuildMediaMessage(inst *types.Instance, recipient whatsappTypes.JID, data []byte, mediaType string, opts MediaOptions) (*waE2E.Message, string, error) {
+	uploadTypes := map[string]whatsmeow.MediaType{
+		"image": whatsmeow.MediaImage,
+		"video": whatsmeow.MediaVideo,
+		"audio": whatsmeow.MediaAudio,
+		"file":  whatsmeow.MediaDocument,
+	}
+	uploadType, ok := uploadTypes[mediaType]
+	if !ok {
+		return nil, "", fmt.Errorf("invalid media type %q", mediaType)
+	}
+
+	uploaded, err := UploadMedia(inst, recipient, data, uploadType)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to upload %s: %v", mediaType, err)
+	}
+	mimeType := http.DetectContentType(data)
+
+	var msg *waE2E.Message
+	switch mediaType {
+	case "image":
+		msg = &waE2E.Message{
+			ImageMessage: &waE2E.ImageMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				Mimetype:      proto.String(mimeType),
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uploaded.FileLength),
+				Caption:       proto.String(opts.Caption),
+			},
+		}
+	case "video":
+		msg = &waE2E.Message{
+			VideoMessage: &waE2E.VideoMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				Mimetype:      proto.String(mimeType),
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uploaded.FileLength),
+				Caption:       proto.String(opts.Caption),
+			},
+		}
+	case "audio":
+		msg = &waE2E.Message{
+			AudioMessage: &waE2E.AudioMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				Mimetype:      proto.String(mimeType),
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uploaded.FileLength),
+				PTT:           proto.Bool(opts.PTT),
+			},
+		}
+	case "file":
+		msg = &waE2E.Message{
+			DocumentMessage: &waE2E.DocumentMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				Mimetype:      proto.String(mimeType),
+				MediaKey:      uploaded.MediaKey,
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    proto.Uint64(uploaded.FileLength),
+				FileName:      proto.String(opts.FileName),
+			},
+		}
+	}
+	return msg, uploaded.Handle, nil
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"multi-client-whatsapp/internal/types"
+	"multi-client-whatsapp/internal/utils"
+
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+)
+
+// mentionTokenRegex matches @number mention tokens in message text
+var mentionTokenRegex = regexp.MustCompile(`(?:^|[^\w@])@(\d{5,20})\b`)
+
+// ResolveMentions turns the requested mentions into the JIDs to put in
+// ContextInfo.MentionedJID. Explicit mentions must match the @number tokens
+// in the text one to one. With mentionAll every participant of the group is
+// mentioned, which doesn't require tokens in the text.
+func ResolveMentions(inst *types.Instance, chat whatsappTypes.JID, text string, mentions []string, mentionAll bool) ([]string, error) {
+	if len(mentions) == 0 && !mentionAll {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	var jids []string
+
+	if len(mentions) > 0 {
+		tokens := make(map[string]bool)
+		for _, match := range mentionTokenRegex.FindAllStringSubmatch(text, -1) {
+			tokens[match[1]] = true
+		}
+
+		mentioned := make(map[string]bool)
+		for _, mention := range mentions {
+			jid, err := utils.ParseJIDWithLIDSupport(strings.TrimPrefix(mention, "@"), inst)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mention %s: %v", mention, err)
+			}
+			jid = jid.ToNonAD()
+			if !tokens[jid.User] {
+				return nil, fmt.Errorf("mention %s has no matching @%s in the text", mention, jid.User)
+			}
+			mentioned[jid.User] = true
+			if !seen[jid.String()] {
+				seen[jid.String()] = true
+				jids = append(jids, jid.String())
+			}
+		}
+
+		for token := range tokens {
+			if !mentioned[token] {
+				return nil, fmt.Errorf("@%s in the text has no matching entry in mentions", token)
+			}
+		}
+	}
+
+	if mentionAll {
+		if chat.Server != whatsappTypes.GroupServer {
+			return nil, fmt.Errorf("mention_all is only supported in groups")
+		}
+		info, err := inst.Client.GetGroupInfo(chat)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get group participants: %v", err)
+		}
+		for _, participant := range info.Participants {
+			jid := participant.JID.ToNonAD().String()
+			if !seen[jid] {
+				seen[jid] = true
+				jids = append(jids, jid)
+			}
+		}
+	}
+
+	return jids, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// maxCachedMessages caps how many recent messages are remembered per instance
+const maxCachedMessages = 5000
+
+// CachedMessage is a recently sent or received message
+type CachedMessage struct {
+	Info    whatsappTypes.MessageInfo
+	Message *waE2E.Message
+}
+
+// messageCache holds the recent messages of a single instance, evicting the
+// oldest entries once it's full
+type messageCache struct {
+	entries map[string]*CachedMessage
+	order   []string
+}
+
+var (
+	messageCaches     = make(map[string]*messageCache)
+	messageCacheMutex sync.RWMutex
+)
+
+// CacheMessage remembers a message so it can be quoted or looked up later
+func CacheMessage(instanceKey string, info whatsappTypes.MessageInfo, msg *waE2E.Message) {
+	if msg == nil || info.ID == "" {
+		return
+	}
+
+	messageCacheMutex.Lock()
+	defer messageCacheMutex.Unlock()
+
+	cache, ok := messageCaches[instanceKey]
+	if !ok {
+		cache = &messageCache{entries: make(map[string]*CachedMessage)}
+		messageCaches[instanceKey] = cache
+	}
+
+	if _, exists := cache.entries[info.ID]; !exists {
+		cache.order = append(cache.order, info.ID)
+	}
+	cache.entries[info.ID] = &CachedMessage{Info: info, Message: msg}
+
+	// Polls need their option names to make sense of the hashed votes later
+	RegisterPoll(instanceKey, info.ID, info.Chat.String(), msg)
+
+	if len(cache.order) > maxCachedMessages {
+		evicted := cache.order[:len(cache.order)-maxCachedMessages]
+		for _, id := range evicted {
+			delete(cache.entries, id)
+		}
+		cache.order = append([]string(nil), cache.order[len(evicted):]...)
+	}
+}
+
+// CacheReceivedMessage remembers an inbound (or own-device) message event.
+// View once content is kept wrapped, so it isn't treated as a normal
+// message when forwarded.
+func CacheReceivedMessage(instanceKey string, evt *events.Message) {
+	msg := evt.Message
+	if evt.IsViewOnce && msg.GetViewOnceMessage() == nil {
+		msg = &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{Message: msg}}
+	}
+	CacheMessage(instanceKey, evt.Info, msg)
+}
+
+// CacheSentMessage remembers a message sent through the bridge and starts
+// tracking its delivery status
+func CacheSentMessage(inst *types.Instance, chat whatsappTypes.JID, resp whatsmeow.SendResponse, msg *waE2E.Message) {
+	info := whatsappTypes.MessageInfo{
+		MessageSource: whatsappTypes.MessageSource{
+			Chat:     chat,
+			IsFromMe: true,
+			IsGroup:  chat.Server == whatsappTypes.GroupServer,
+		},
+		ID:        resp.ID,
+		Timestamp: resp.Timestamp,
+	}
+	if inst.Client.Store.ID != nil {
+		info.Sender = inst.Client.Store.ID.ToNonAD()
+	}
+	if info.Timestamp.IsZero() {
+		info.Timestamp = time.Now()
+	}
+	CacheMessage(inst.ID, info, msg)
+	// Recorded before returning, a fast receipt must find the message
+	RecordSentMessage(inst.ID, chat, resp)
+}
+
+// GetCachedMessage looks up a recent message by ID
+func GetCachedMessage(instanceKey, messageID string) (*CachedMessage, bool) {
+	messageCacheMutex.RLock()
+	defer messageCacheMutex.RUnlock()
+
+	cache, ok := messageCaches[instanceKey]
+	if !ok {
+		return nil, false
+	}
+	cached, ok := cache.entries[messageID]
+	return cached, ok
+}
+
+// ForgetCachedMessages drops all cached messages for an instance
+func ForgetCachedMessages(instanceKey string) {
+	messageCacheMutex.Lock()
+	delete(messageCaches, instanceKey)
+	messageCacheMutex.Unlock()
+}
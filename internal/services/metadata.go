@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+
+	"multi-client-whatsapp/internal/platform/database"
+
+	"github.com/lib/pq"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+var (
+	ErrInvalidMetadata = errors.New("invalid metadata")
+	ErrMessageIDInUse  = errors.New("custom_message_id was already used for another message")
+)
+
+// maxMetadataSize limits the metadata stored with a message
+const maxMetadataSize = 16 * 1024
+
+// customMessageIDRegex matches the IDs WhatsApp clients generate themselves:
+// uppercase hex, like 3EB0C767D82B3C2E
+var customMessageIDRegex = regexp.MustCompile(`^[0-9A-F]{16,64}$`)
+
+// ValidateMessageMetadata checks that metadata is a JSON object of a reasonable size
+func ValidateMessageMetadata(metadata json.RawMessage) error {
+	if len(metadata) > maxMetadataSize {
+		return fmt.Errorf("%w: metadata must be at most %d bytes", ErrInvalidMetadata, maxMetadataSize)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(metadata), []byte("{")) {
+		return fmt.Errorf("%w: metadata must be a JSON object", ErrInvalidMetadata)
+	}
+	return nil
+}
+
+// ValidateCustomMessageID checks a caller chosen message ID, and that the
+// instance hasn't sent a message with it yet
+func ValidateCustomMessageID(instanceKey, messageID string) error {
+	if !customMessageIDRegex.MatchString(messageID) {
+		return fmt.Errorf("%w: custom_message_id must be 16 to 64 uppercase hex characters", ErrInvalidMetadata)
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		// Without the bridge database there's nothing to check against
+		return nil
+	}
+	var used bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM message_status WHERE instance_key = $1 AND message_id = $2)
+		OR EXISTS (SELECT 1 FROM message_metadata WHERE instance_key = $1 AND message_id = $2)`,
+		instanceKey, messageID).Scan(&used)
+	if err != nil {
+		return fmt.Errorf("failed to check custom_message_id: %w", err)
+	}
+	if used {
+		return ErrMessageIDInUse
+	}
+	return nil
+}
+
+// RecordMessageMetadata stores the caller's metadata against the messages a send produced
+func RecordMessageMetadata(instanceKey string, messageIDs []string, metadata json.RawMessage) {
+	if len(messageIDs) == 0 || len(metadata) == 0 {
+		return
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		log.Printf("Metadata: failed to record metadata of %v: %v", messageIDs, err)
+		return
+	}
+
+	_, err = db.Exec(`INSERT INTO message_metadata (instance_key, message_id, metadata)
+		SELECT $1::text, unnest($2::text[]), $3::jsonb
+		ON CONFLICT (instance_key, message_id) DO UPDATE SET metadata = EXCLUDED.metadata`,
+		instanceKey, pq.Array(messageIDs), string(metadata))
+	if err != nil {
+		log.Printf("Metadata: failed to record metadata of %v: %v", messageIDs, err)
+	}
+}
+
+// LookupMessageMetadata returns the metadata stored for any of the messages, by message ID
+func LookupMessageMetadata(instanceKey string, messageIDs []string) map[string]json.RawMessage {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil
+	}
+
+	rows, err := db.Query(`SELECT message_id, metadata FROM message_metadata
+		WHERE instance_key = $1 AND message_id = ANY($2)`, instanceKey, pq.Array(messageIDs))
+	if err != nil {
+		log.Printf("Metadata: failed to look up metadata: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var found map[string]json.RawMessage
+	for rows.Next() {
+		var messageID string
+		var metadata []byte
+		if err := rows.Scan(&messageID, &metadata); err != nil {
+			log.Printf("Metadata: failed to read metadata: %v", err)
+			return found
+		}
+		if found == nil {
+			found = map[string]json.RawMessage{}
+		}
+		found[messageID] = metadata
+	}
+	return found
+}
+
+// webhookMessageIDs returns the IDs of the messages a webhook event is about:
+// the receipted messages, the message itself, and the message an edit,
+// revoke, reaction, poll vote or quoted reply refers to
+func webhookMessageIDs(data interface{}) []string {
+	switch evt := data.(type) {
+	case *events.Receipt:
+		return evt.MessageIDs
+	case *events.Message:
+		evt = unwrapMessageEvent(evt)
+		msg := evt.Message
+		var ids []string
+		for _, id := range []string{
+			evt.Info.ID,
+			msg.GetProtocolMessage().GetKey().GetID(),
+			msg.GetReactionMessage().GetKey().GetID(),
+			msg.GetPollUpdateMessage().GetPollCreationMessageKey().GetID(),
+			GetContextInfo(msg).GetStanzaID(),
+		} {
+			if id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	return nil
+}
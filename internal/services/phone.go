@@ -242,21 +242,7 @@ func ValidateAndCorrectPhone(phone string, instance *types.Instance) (string, er
 			return phone, fmt.Errorf("invalid LID format: %v", err)
 		}
 
-		// Try to get phone number for this LID
-		if instance.Client != nil && instance.Client.Store != nil && instance.Client.Store.LIDs != nil {
-			ctx := context.Background()
-			pn, err := instance.Client.Store.LIDs.GetPNForLID(ctx, lidJID)
-			if err != nil {
-				log.Printf("Warning: Could not get phone number for LID %s: %v", lidJID.String(), err)
-				return lidJID.String(), nil
-			}
-			if !pn.IsEmpty() {
-				log.Printf("Resolved LID %s to phone number %s", lidJID.String(), pn.String())
-				return pn.String(), nil
-			}
-		}
-
-		return lidJID.String(), nil
+		return resolveLID(lidJID, instance).String(), nil
 	}
 
 	// If it doesn't end with @s.whatsapp.net or @lid, try Brazilian validation
@@ -295,3 +281,21 @@ func ValidateAndCorrectPhone(phone string, instance *types.Instance) (string, er
 
 	return phone, fmt.Errorf("invalid phone number format: %s", phone)
 }
+
+// resolveLID returns the phone number JID for a LID when the store knows it,
+// or the LID itself otherwise
+func resolveLID(lidJID whatsappTypes.JID, instance *types.Instance) whatsappTypes.JID {
+	if instance.Client != nil && instance.Client.Store != nil && instance.Client.Store.LIDs != nil {
+		ctx := context.Background()
+		pn, err := instance.Client.Store.LIDs.GetPNForLID(ctx, lidJID)
+		if err != nil {
+			log.Printf("Warning: Could not get phone number for LID %s: %v", lidJID.String(), err)
+			return lidJID
+		}
+		if !pn.IsEmpty() {
+			log.Printf("Resolved LID %s to phone number %s", lidJID.String(), pn.String())
+			return pn
+		}
+	}
+	return lidJID
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// pollState holds a poll's options and the latest selection of every voter
+type pollState struct {
+	ID        string
+	Chat      string
+	Question  string
+	Options   []string
+	hashes    map[string]string   // hex SHA-256 of option name -> option name
+	Votes     map[string][]string // voter JID -> selected option names
+	UpdatedAt time.Time
+}
+
+// maxTrackedPolls caps how many polls are tallied per instance
+const maxTrackedPolls = 1000
+
+var (
+	// polls maps instance key -> poll message ID -> poll state
+	polls      = make(map[string]map[string]*pollState)
+	pollsMutex sync.Mutex
+)
+
+// pollCreation returns the poll creation content of a message, whichever version it uses
+func pollCreation(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+// RegisterPoll starts tracking votes for a poll message. Messages that aren't
+// polls are ignored, so this can be called for every sent or received message.
+func RegisterPoll(instanceKey, messageID, chat string, msg *waE2E.Message) {
+	creation := pollCreation(msg)
+	if creation == nil {
+		return
+	}
+
+	state := &pollState{
+		ID:        messageID,
+		Chat:      chat,
+		Question:  creation.GetName(),
+		hashes:    make(map[string]string),
+		Votes:     make(map[string][]string),
+		UpdatedAt: time.Now(),
+	}
+	for _, option := range creation.GetOptions() {
+		name := option.GetOptionName()
+		state.Options = append(state.Options, name)
+		state.hashes[hex.EncodeToString(whatsmeow.HashPollOptions([]string{name})[0])] = name
+	}
+
+	pollsMutex.Lock()
+	defer pollsMutex.Unlock()
+
+	instancePolls, ok := polls[instanceKey]
+	if !ok {
+		instancePolls = make(map[string]*pollState)
+		polls[instanceKey] = instancePolls
+	}
+	if _, exists := instancePolls[messageID]; exists {
+		return
+	}
+	instancePolls[messageID] = state
+
+	// Drop the poll that went the longest without a vote
+	if len(instancePolls) > maxTrackedPolls {
+		var oldest *pollState
+		for _, poll := range instancePolls {
+			if oldest == nil || poll.UpdatedAt.Before(oldest.UpdatedAt) {
+				oldest = poll
+			}
+		}
+		delete(instancePolls, oldest.ID)
+	}
+}
+
+// RecordPollVote decrypts an inbound poll vote, updates the poll's tally and
+// returns the vote with the selected option names
+func RecordPollVote(inst *types.Instance, evt *events.Message) (*types.PollVote, error) {
+	pollUpdate := evt.Message.GetPollUpdateMessage()
+	if pollUpdate == nil {
+		return nil, fmt.Errorf("message is not a poll vote")
+	}
+	pollID := pollUpdate.GetPollCreationMessageKey().GetID()
+
+	vote, err := inst.Client.DecryptPollVote(context.Background(), evt)
+	if err != nil {
+		return nil, err
+	}
+
+	voter := evt.Info.Sender.ToNonAD().String()
+	result := &types.PollVote{
+		PollID:          pollID,
+		Voter:           voter,
+		SelectedOptions: []string{},
+		Timestamp:       evt.Info.Timestamp,
+	}
+
+	pollsMutex.Lock()
+	defer pollsMutex.Unlock()
+
+	state, ok := polls[inst.ID][pollID]
+	for _, hash := range vote.GetSelectedOptions() {
+		key := hex.EncodeToString(hash)
+		if ok {
+			if name, known := state.hashes[key]; known {
+				result.SelectedOptions = append(result.SelectedOptions, name)
+				continue
+			}
+		}
+		result.UnknownHashes = append(result.UnknownHashes, key)
+	}
+
+	if !ok {
+		// The poll was created before the bridge started, so the option
+		// names can't be recovered from the hashes
+		return result, nil
+	}
+
+	// Every vote carries the voter's full selection, an empty one means the vote was removed
+	if len(result.SelectedOptions) == 0 {
+		delete(state.Votes, voter)
+	} else {
+		state.Votes[voter] = result.SelectedOptions
+	}
+	state.UpdatedAt = time.Now()
+	result.Tally = state.tally()
+	return result, nil
+}
+
+// GetPollTally returns the current aggregated results of a poll
+func GetPollTally(instanceKey, pollID string) (*types.PollTally, bool) {
+	pollsMutex.Lock()
+	defer pollsMutex.Unlock()
+
+	state, ok := polls[instanceKey][pollID]
+	if !ok {
+		return nil, false
+	}
+	return state.tally(), true
+}
+
+// ForgetPolls drops all tracked polls for an instance
+func ForgetPolls(instanceKey string) {
+	pollsMutex.Lock()
+	delete(polls, instanceKey)
+	pollsMutex.Unlock()
+}
+
+// tally aggregates the votes of a poll, must be called with pollsMutex held
+func (p *pollState) tally() *types.PollTally {
+	voters := make(map[string][]string)
+	for _, option := range p.Options {
+		voters[option] = []string{}
+	}
+	for voter, selected := range p.Votes {
+		for _, option := range selected {
+			voters[option] = append(voters[option], voter)
+		}
+	}
+
+	tally := &types.PollTally{
+		PollID:      p.ID,
+		Chat:        p.Chat,
+		Question:    p.Question,
+		Options:     make([]types.PollOptionTally, 0, len(p.Options)),
+		TotalVoters: len(p.Votes),
+		UpdatedAt:   p.UpdatedAt,
+	}
+	for _, option := range p.Options {
+		sort.Strings(voters[option])
+		tally.Options = append(tally.Options, types.PollOptionTally{
+			Name:   option,
+			Votes:  len(voters[option]),
+			Voters: voters[option],
+		})
+	}
+	return tally
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+)
+
+// Typing simulation timing, the composing indicator is shown for
+// typingPerCharacter per character of text, clamped between the min and max
+const (
+	typingPerCharacter = 50 * time.Millisecond
+	minTypingDuration  = 1 * time.Second
+	maxTypingDuration  = 10 * time.Second
+)
+
+// SetChatPresence sends a composing, recording or paused indicator to a chat
+func SetChatPresence(inst *types.Instance, chat whatsappTypes.JID, state string) error {
+	switch state {
+	case "composing":
+		return inst.Client.SendChatPresence(chat, whatsappTypes.ChatPresenceComposing, whatsappTypes.ChatPresenceMediaText)
+	case "recording":
+		return inst.Client.SendChatPresence(chat, whatsappTypes.ChatPresenceComposing, whatsappTypes.ChatPresenceMediaAudio)
+	case "paused":
+		return inst.Client.SendChatPresence(chat, whatsappTypes.ChatPresencePaused, whatsappTypes.ChatPresenceMediaText)
+	default:
+		return fmt.Errorf("invalid chat presence state: %s", state)
+	}
+}
+
+// SetGlobalPresence marks the instance as available (online) or unavailable
+func SetGlobalPresence(inst *types.Instance, state string) error {
+	switch state {
+	case "available":
+		return inst.Client.SendPresence(whatsappTypes.PresenceAvailable)
+	case "unavailable":
+		return inst.Client.SendPresence(whatsappTypes.PresenceUnavailable)
+	default:
+		return fmt.Errorf("invalid presence state: %s", state)
+	}
+}
+
+// TypingDuration returns how long the composing indicator is shown for a text
+func TypingDuration(text string) time.Duration {
+	duration := time.Duration(len([]rune(text))) * typingPerCharacter
+	if duration < minTypingDuration {
+		return minTypingDuration
+	}
+	if duration > maxTypingDuration {
+		return maxTypingDuration
+	}
+	return duration
+}
+
+// SimulateTyping shows the composing indicator in a chat for a duration
+// proportional to the text length and pauses it again afterwards
+func SimulateTyping(inst *types.Instance, chat whatsappTypes.JID, text string) error {
+	if err := SetChatPresence(inst, chat, "composing"); err != nil {
+		return err
+	}
+	time.Sleep(TypingDuration(text))
+	return SetChatPresence(inst, chat, "paused")
+}
@@ -0,0 +1,708 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/platform/database"
+	"multi-client-whatsapp/internal/types"
+
+	"github.com/google/uuid"
+)
+
+// Queue tuning, the pacing defaults can be overridden with QUEUE_* environment
+// variables and per instance through the API
+const (
+	queuePollInterval   = 2 * time.Second
+	queueWorkerIdle     = time.Minute // Idle workers exit, they are restarted when work shows up
+	queueRetryBaseDelay = 10 * time.Second
+)
+
+// Queue item statuses
+const (
+	QueuePending   = "pending"
+	QueueSending   = "sending"
+	QueueSent      = "sent"
+	QueueFailed    = "failed"
+	QueueCancelled = "cancelled"
+)
+
+// queuePriorities maps priority lanes to their sort order, higher goes first
+var queuePriorities = map[string]int{"low": 0, "normal": 1, "high": 2}
+
+// queueFields are the request fields that control queueing, they are
+// stripped from the stored payload
+var queueFields = []string{"async", "priority"}
+
+var (
+	ErrQueuedMessageNotFound   = errors.New("queued message not found")
+	ErrQueuedMessageNotPending = errors.New("queued message is no longer pending")
+)
+
+// queueDefaults are the pacing settings of instances without their own
+var queueDefaults = types.QueueSettings{
+	RatePerMinute: 20,
+	JitterMs:      3000,
+	DailyCap:      0,
+}
+
+var (
+	queueMaxAttempts = 5
+	queueSyncTimeout = 30 * time.Second
+)
+
+// queueBypassKey marks requests replayed by a queue worker, so the queue
+// middleware lets them through to the send handler. It lives in the request
+// context, so API clients can't set it.
+type queueBypassKey struct{}
+
+// queueResult is the response of the send handler for a queued message
+type queueResult struct {
+	Code int
+	Body []byte
+}
+
+// queueWorker sends the queued messages of one instance, one at a time
+type queueWorker struct {
+	instanceKey string
+	wake        chan struct{}
+	lastSent    time.Time
+}
+
+var (
+	// queueWorkers maps instance key -> running worker
+	queueWorkers = make(map[string]*queueWorker)
+	// queueWaiters maps queue item ID -> channel of callers awaiting the result
+	queueWaiters = make(map[string]chan queueResult)
+	queueMutex   sync.Mutex
+)
+
+const queuedMessageColumns = `id, instance_key, endpoint, payload, priority, status, attempts, next_attempt_at,
+	response_code, response, message_id, last_error, created_at, updated_at, sent_at`
+
+// StartQueue starts the outbound queue. Workers replay queued send requests
+// through handler, one worker per instance.
+func StartQueue(handler http.Handler) {
+	replayHandler = handler
+	loadQueueConfig()
+
+	if db, err := database.BridgeDB(); err != nil {
+		log.Printf("Queue: %v", err)
+	} else {
+		// A send interrupted by a restart may or may not have reached WhatsApp,
+		// don't risk sending it twice
+		_, err := db.Exec(`UPDATE outbound_queue SET status = 'failed', last_error = 'interrupted by restart, delivery unknown', updated_at = now()
+			WHERE status = 'sending'`)
+		if err != nil {
+			log.Printf("Queue: failed to reset interrupted messages: %v", err)
+		}
+	}
+
+	go func() {
+		for {
+			startDueWorkers()
+			time.Sleep(schedulerInterval)
+		}
+	}()
+	log.Printf("Outbound queue started (%d/min, %dms jitter, daily cap %d)", queueDefaults.RatePerMinute, queueDefaults.JitterMs, queueDefaults.DailyCap)
+}
+
+// loadQueueConfig reads the queue defaults from the environment
+func loadQueueConfig() {
+	envInt := func(name string, target *int) {
+		if value := os.Getenv(name); value != "" {
+			if number, err := strconv.Atoi(value); err == nil && number >= 0 {
+				*target = number
+			} else {
+				log.Printf("Invalid %s %q, using %d", name, value, *target)
+			}
+		}
+	}
+	envInt("QUEUE_RATE_PER_MINUTE", &queueDefaults.RatePerMinute)
+	envInt("QUEUE_JITTER_MS", &queueDefaults.JitterMs)
+	envInt("QUEUE_DAILY_CAP", &queueDefaults.DailyCap)
+	envInt("QUEUE_MAX_ATTEMPTS", &queueMaxAttempts)
+
+	syncTimeout := int(queueSyncTimeout / time.Second)
+	envInt("QUEUE_SYNC_TIMEOUT", &syncTimeout)
+	queueSyncTimeout = time.Duration(syncTimeout) * time.Second
+}
+
+// IsQueueReplay reports whether a request was replayed by a queue worker
+func IsQueueReplay(ctx context.Context) bool {
+	return ctx.Value(queueBypassKey{}) != nil
+}
+
+// QueuePriority validates a priority lane name, "" means normal
+func QueuePriority(priority string) (string, error) {
+	if priority == "" {
+		return "normal", nil
+	}
+	if _, ok := queuePriorities[priority]; !ok {
+		return "", fmt.Errorf("priority must be one of: high, normal, low")
+	}
+	return priority, nil
+}
+
+// StripQueueFields removes the queueing fields from a send request body
+func StripQueueFields(body map[string]json.RawMessage) {
+	for _, field := range queueFields {
+		delete(body, field)
+	}
+}
+
+// QueueSyncTimeout is how long a synchronous send waits for its queued message
+func QueueSyncTimeout() time.Duration {
+	return queueSyncTimeout
+}
+
+// EnqueueMessage stores a send request in the instance's queue and wakes its worker
+func EnqueueMessage(msg *types.QueuedMessage) error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	msg.ID = uuid.New().String()
+	msg.Status = QueuePending
+	msg.CreatedAt = time.Now()
+	msg.UpdatedAt = msg.CreatedAt
+	msg.NextAttemptAt = msg.CreatedAt
+
+	_, err = db.Exec(`INSERT INTO outbound_queue
+		(id, instance_key, endpoint, payload, priority, status, next_attempt_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		msg.ID, msg.InstanceKey, msg.Endpoint, string(msg.Payload), queuePriorities[msg.Priority],
+		msg.Status, msg.NextAttemptAt, msg.CreatedAt, msg.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to queue message: %w", err)
+	}
+
+	startQueueWorker(msg.InstanceKey)
+	return nil
+}
+
+// AwaitQueuedMessage waits until a queued message was sent or failed and
+// returns the send handler's response. ok is false if the wait timed out or
+// was cancelled, the message then stays queued.
+func AwaitQueuedMessage(ctx context.Context, instanceKey, id string) (int, []byte, bool) {
+	waiter := make(chan queueResult, 1)
+	queueMutex.Lock()
+	queueWaiters[id] = waiter
+	queueMutex.Unlock()
+
+	defer func() {
+		queueMutex.Lock()
+		delete(queueWaiters, id)
+		queueMutex.Unlock()
+	}()
+
+	// The worker may have been faster than us
+	if msg, err := GetQueuedMessage(instanceKey, id); err == nil && msg.Status != QueuePending && msg.Status != QueueSending {
+		if msg.Status == QueueCancelled {
+			return 409, []byte(`{"error":"Queued message was cancelled"}`), true
+		}
+		return msg.ResponseCode, msg.Response, true
+	}
+
+	timer := time.NewTimer(queueSyncTimeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-waiter:
+		return result.Code, result.Body, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return 0, nil, false
+}
+
+// ListQueuedMessages returns the queue of an instance, optionally filtered by status
+func ListQueuedMessages(instanceKey, status string) ([]types.QueuedMessage, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+queuedMessageColumns+` FROM outbound_queue
+		WHERE instance_key = $1 AND ($2 = '' OR status = $2)
+		ORDER BY priority DESC, created_at
+		LIMIT 500`, instanceKey, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queued messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []types.QueuedMessage{}
+	for rows.Next() {
+		msg, err := scanQueuedMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+	return messages, rows.Err()
+}
+
+// GetQueuedMessage returns a single queued message of an instance
+func GetQueuedMessage(instanceKey, id string) (*types.QueuedMessage, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	row := db.QueryRow(`SELECT `+queuedMessageColumns+` FROM outbound_queue
+		WHERE instance_key = $1 AND id = $2`, instanceKey, id)
+	msg, err := scanQueuedMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrQueuedMessageNotFound
+	}
+	return msg, err
+}
+
+// CancelQueuedMessage removes a message from the queue before it is sent
+func CancelQueuedMessage(instanceKey, id string) (*types.QueuedMessage, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`UPDATE outbound_queue SET status = 'cancelled', updated_at = now()
+		WHERE instance_key = $1 AND id = $2 AND status = 'pending'`, instanceKey, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel queued message: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		if _, err := GetQueuedMessage(instanceKey, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrQueuedMessageNotPending
+	}
+
+	msg, err := GetQueuedMessage(instanceKey, id)
+	if err != nil {
+		return nil, err
+	}
+	notifyQueueWaiter(id, queueResult{Code: 409, Body: []byte(`{"error":"Queued message was cancelled"}`)})
+	return msg, nil
+}
+
+// DeleteQueuedMessages removes the whole queue of a deleted instance
+func DeleteQueuedMessages(instanceKey string) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		log.Printf("Warning: Error deleting queued messages of instance %s: %v", instanceKey, err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM outbound_queue WHERE instance_key = $1`, instanceKey); err != nil {
+		log.Printf("Warning: Error deleting queued messages of instance %s: %v", instanceKey, err)
+	}
+	if _, err := db.Exec(`DELETE FROM queue_settings WHERE instance_key = $1`, instanceKey); err != nil {
+		log.Printf("Warning: Error deleting queue settings of instance %s: %v", instanceKey, err)
+	}
+}
+
+// GetQueueSettings returns the pacing settings of an instance with its
+// current counters. Instances without their own settings use the defaults.
+func GetQueueSettings(instanceKey string) (*types.QueueSettings, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := queueDefaults
+	settings.InstanceKey = instanceKey
+	settings.Default = true
+
+	err = db.QueryRow(`SELECT rate_per_minute, jitter_ms, daily_cap FROM queue_settings WHERE instance_key = $1`, instanceKey).
+		Scan(&settings.RatePerMinute, &settings.JitterMs, &settings.DailyCap)
+	switch {
+	case err == nil:
+		settings.Default = false
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("failed to load queue settings: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT
+			count(*) FILTER (WHERE status = 'sent' AND sent_at >= $2),
+			count(*) FILTER (WHERE status IN ('pending', 'sending'))
+		FROM outbound_queue WHERE instance_key = $1`, instanceKey, startOfDay(time.Now())).
+		Scan(&settings.SentToday, &settings.Pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queued messages: %w", err)
+	}
+	return &settings, nil
+}
+
+// UpdateQueueSettings stores pacing settings for an instance, omitted fields keep their current value
+func UpdateQueueSettings(instanceKey string, req *types.QueueSettingsRequest) (*types.QueueSettings, error) {
+	current, err := GetQueueSettings(instanceKey)
+	if err != nil {
+		return nil, err
+	}
+	if req.RatePerMinute != nil {
+		current.RatePerMinute = *req.RatePerMinute
+	}
+	if req.JitterMs != nil {
+		current.JitterMs = *req.JitterMs
+	}
+	if req.DailyCap != nil {
+		current.DailyCap = *req.DailyCap
+	}
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`INSERT INTO queue_settings (instance_key, rate_per_minute, jitter_ms, daily_cap, updated_at)
+		VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (instance_key) DO UPDATE
+		SET rate_per_minute = $2, jitter_ms = $3, daily_cap = $4, updated_at = now()`,
+		instanceKey, current.RatePerMinute, current.JitterMs, current.DailyCap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store queue settings: %w", err)
+	}
+	current.Default = false
+
+	// Let the worker pick up the new pacing right away
+	wakeQueueWorker(instanceKey)
+	return current, nil
+}
+
+// startDueWorkers starts workers for instances with due messages, e.g.
+// after a restart or when a retry delay has passed
+func startDueWorkers() {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return
+	}
+
+	rows, err := db.Query(`SELECT DISTINCT instance_key FROM outbound_queue
+		WHERE status = 'pending' AND next_attempt_at <= now()`)
+	if err != nil {
+		log.Printf("Queue: failed to load due instances: %v", err)
+		return
+	}
+	var instanceKeys []string
+	for rows.Next() {
+		var instanceKey string
+		if err := rows.Scan(&instanceKey); err == nil {
+			instanceKeys = append(instanceKeys, instanceKey)
+		}
+	}
+	rows.Close()
+
+	for _, instanceKey := range instanceKeys {
+		if instanceConnected(instanceKey) {
+			startQueueWorker(instanceKey)
+		}
+	}
+}
+
+// startQueueWorker starts the worker of an instance unless it's running, in which case it's woken up
+func startQueueWorker(instanceKey string) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	if worker, ok := queueWorkers[instanceKey]; ok {
+		worker.signal()
+		return
+	}
+	worker := &queueWorker{instanceKey: instanceKey, wake: make(chan struct{}, 1)}
+	queueWorkers[instanceKey] = worker
+	go worker.run()
+}
+
+func wakeQueueWorker(instanceKey string) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if worker, ok := queueWorkers[instanceKey]; ok {
+		worker.signal()
+	}
+}
+
+func (w *queueWorker) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait sleeps for d or until the worker is woken up
+func (w *queueWorker) wait(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-w.wake:
+	}
+}
+
+func (w *queueWorker) run() {
+	log.Printf("Queue: worker started for instance %s", w.instanceKey)
+	idleSince := time.Now()
+
+	for {
+		db, err := database.BridgeDB()
+		if err != nil {
+			log.Printf("Queue: %v", err)
+			w.wait(queuePollInterval)
+			continue
+		}
+
+		if !instanceConnected(w.instanceKey) {
+			// Messages stay queued until the instance is back
+			if w.stopIfIdle(idleSince) {
+				return
+			}
+			w.wait(queuePollInterval)
+			continue
+		}
+
+		settings, err := GetQueueSettings(w.instanceKey)
+		if err != nil {
+			log.Printf("Queue: %v", err)
+			w.wait(queuePollInterval)
+			continue
+		}
+
+		if settings.DailyCap > 0 && settings.SentToday >= settings.DailyCap {
+			if settings.Pending == 0 && w.stopIfIdle(idleSince) {
+				return
+			}
+			w.wait(time.Minute)
+			continue
+		}
+
+		if delay := w.pacingDelay(settings); delay > 0 {
+			w.wait(delay)
+			continue
+		}
+
+		msg, err := claimQueuedMessage(db, w.instanceKey)
+		if err != nil {
+			log.Printf("Queue: %v", err)
+			w.wait(queuePollInterval)
+			continue
+		}
+		if msg == nil {
+			if w.stopIfIdle(idleSince) {
+				return
+			}
+			w.wait(queuePollInterval)
+			continue
+		}
+
+		w.send(db, msg, settings)
+		idleSince = time.Now()
+	}
+}
+
+// pacingDelay returns how long to wait before the next send to keep to the rate limit
+func (w *queueWorker) pacingDelay(settings *types.QueueSettings) time.Duration {
+	if settings.RatePerMinute <= 0 || w.lastSent.IsZero() {
+		return 0
+	}
+	next := w.lastSent.Add(time.Minute / time.Duration(settings.RatePerMinute))
+	return time.Until(next)
+}
+
+// stopIfIdle removes the worker once it had nothing to do for a while
+func (w *queueWorker) stopIfIdle(idleSince time.Time) bool {
+	if time.Since(idleSince) < queueWorkerIdle {
+		return false
+	}
+
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	// Don't exit if someone queued a message and woke us meanwhile
+	select {
+	case <-w.wake:
+		return false
+	default:
+	}
+	delete(queueWorkers, w.instanceKey)
+	log.Printf("Queue: worker stopped for instance %s", w.instanceKey)
+	return true
+}
+
+// send replays one queued message and records the outcome
+func (w *queueWorker) send(db *sql.DB, msg *types.QueuedMessage, settings *types.QueueSettings) {
+	if settings.JitterMs > 0 && !w.lastSent.IsZero() {
+		time.Sleep(time.Duration(rand.Intn(settings.JitterMs)) * time.Millisecond)
+	}
+
+	ctx := context.WithValue(context.Background(), queueBypassKey{}, msg.ID)
+	code, body := replaySendRequest(ctx, msg.Endpoint, msg.Payload)
+	w.lastSent = time.Now()
+
+	var resp struct {
+		MessageID string `json:"message_id"`
+		Error     string `json:"error"`
+	}
+	json.Unmarshal(body, &resp)
+
+	now := time.Now()
+	msg.Attempts++
+	msg.ResponseCode = code
+	msg.Response = body
+	msg.UpdatedAt = now
+
+	switch {
+	case code == http.StatusOK:
+		msg.Status = QueueSent
+		msg.MessageID = resp.MessageID
+		msg.LastError = ""
+		msg.SentAt = &now
+	case instanceOffline(code, body):
+		// Disconnected between our check and the send, wait for the instance
+		msg.Status = QueuePending
+		msg.Attempts--
+		msg.NextAttemptAt = now.Add(queuePollInterval)
+		msg.LastError = resp.Error
+	case code >= 500 && SendNotAttempted(body) && msg.Attempts < queueMaxAttempts:
+		// Failed before reaching WhatsApp, e.g. downloading the media. Other
+		// failures, like an ack timeout, may have been delivered anyway and
+		// aren't retried so the message isn't sent twice.
+		msg.Status = QueuePending
+		msg.NextAttemptAt = now.Add(time.Duration(msg.Attempts) * queueRetryBaseDelay)
+		msg.LastError = resp.Error
+	default:
+		msg.Status = QueueFailed
+		msg.LastError = firstNonEmpty(resp.Error, string(body))
+	}
+
+	if msg.Status == QueuePending {
+		log.Printf("Queue: message %s of instance %s will be retried: %s", msg.ID, msg.InstanceKey, msg.LastError)
+	} else {
+		log.Printf("Queue: message %s of instance %s %s", msg.ID, msg.InstanceKey, msg.Status)
+	}
+
+	var response interface{}
+	if json.Valid(body) {
+		response = string(body)
+	}
+	_, err := db.Exec(`UPDATE outbound_queue
+		SET status = $2, attempts = $3, next_attempt_at = $4, response_code = $5, response = $6,
+			message_id = $7, last_error = $8, updated_at = $9, sent_at = $10
+		WHERE id = $1`,
+		msg.ID, msg.Status, msg.Attempts, msg.NextAttemptAt, msg.ResponseCode, response,
+		msg.MessageID, msg.LastError, msg.UpdatedAt, msg.SentAt)
+	if err != nil {
+		log.Printf("Queue: failed to update queued message %s: %v", msg.ID, err)
+	}
+
+	if msg.Status != QueuePending {
+		notifyQueueWaiter(msg.ID, queueResult{Code: code, Body: body})
+	}
+}
+
+// claimQueuedMessage takes the next due message of an instance, highest priority first
+func claimQueuedMessage(db *sql.DB, instanceKey string) (*types.QueuedMessage, error) {
+	row := db.QueryRow(`UPDATE outbound_queue SET status = 'sending', updated_at = now()
+		WHERE id = (
+			SELECT id FROM outbound_queue
+			WHERE instance_key = $1 AND status = 'pending' AND next_attempt_at <= now()
+			ORDER BY priority DESC, created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+queuedMessageColumns, instanceKey)
+	msg, err := scanQueuedMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim queued message: %w", err)
+	}
+	return msg, nil
+}
+
+func notifyQueueWaiter(id string, result queueResult) {
+	queueMutex.Lock()
+	waiter, ok := queueWaiters[id]
+	queueMutex.Unlock()
+	if ok {
+		select {
+		case waiter <- result:
+		default:
+		}
+	}
+}
+
+// SendNotAttempted reports whether a failed send response says nothing was
+// handed to WhatsApp, so the request is safe to repeat
+func SendNotAttempted(body []byte) bool {
+	var resp struct {
+		Sent *bool `json:"sent"`
+	}
+	return json.Unmarshal(body, &resp) == nil && resp.Sent != nil && !*resp.Sent
+}
+
+// instanceOffline reports whether a send response says the instance wasn't
+// connected, so the message should wait for it rather than fail
+func instanceOffline(code int, body []byte) bool {
+	return code == http.StatusServiceUnavailable && SendNotAttempted(body)
+}
+
+// replaySendRequest runs a stored send request through the API handler and
+// returns the response status and body
+func replaySendRequest(ctx context.Context, endpoint string, payload []byte) (int, []byte) {
+	if replayHandler == nil {
+		return 500, []byte(`{"error":"outbound queue is not started"}`)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 400, []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	replayHandler.ServeHTTP(recorder, req)
+	return recorder.Code, recorder.Body.Bytes()
+}
+
+// startOfDay returns midnight UTC of the day t falls on, daily caps reset then
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func scanQueuedMessage(row rowScanner) (*types.QueuedMessage, error) {
+	msg := &types.QueuedMessage{}
+	var payload, response []byte
+	var priority int
+	var responseCode sql.NullInt64
+	var sentAt sql.NullTime
+	err := row.Scan(&msg.ID, &msg.InstanceKey, &msg.Endpoint, &payload, &priority, &msg.Status, &msg.Attempts,
+		&msg.NextAttemptAt, &responseCode, &response, &msg.MessageID, &msg.LastError, &msg.CreatedAt,
+		&msg.UpdatedAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = payload
+	if len(response) > 0 {
+		msg.Response = response
+	}
+	for name, value := range queuePriorities {
+		if value == priority {
+			msg.Priority = name
+		}
+	}
+	msg.ResponseCode = int(responseCode.Int64)
+	if sentAt.Valid {
+		msg.SentAt = &sentAt.Time
+	}
+	return msg, nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"multi-client-whatsapp/internal/types"
+
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// unreadMessage is an inbound message that hasn't been marked as read yet
+type unreadMessage struct {
+	ID        whatsappTypes.MessageID
+	Chat      whatsappTypes.JID
+	Sender    whatsappTypes.JID
+	Timestamp time.Time
+	// Key is the unread tracker key the message is stored under, empty for
+	// messages that were never tracked
+	Key string
+}
+
+// maxUnreadPerChat caps how many unread messages are remembered for a single chat
+const maxUnreadPerChat = 500
+
+var (
+	// unreadMessages maps instance key -> chat key -> unread messages in arrival order
+	unreadMessages = make(map[string]map[string][]unreadMessage)
+	unreadMutex    sync.Mutex
+)
+
+// unreadChatKey returns the key a chat's unread messages are stored under.
+// Direct chats addressed by LID are stored under the phone number JID when
+// known, since that's what ResolveChatJID returns for them.
+func unreadChatKey(source whatsappTypes.MessageSource) string {
+	if !source.IsGroup && source.Chat.Server == whatsappTypes.HiddenUserServer && source.SenderAlt.Server == whatsappTypes.DefaultUserServer {
+		return source.SenderAlt.ToNonAD().String()
+	}
+	return source.Chat.ToNonAD().String()
+}
+
+// TrackUnreadMessage remembers an inbound message so it can be marked as read later
+func TrackUnreadMessage(instanceKey string, evt *events.Message) {
+	if evt.Info.IsFromMe {
+		return
+	}
+
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+
+	chats, ok := unreadMessages[instanceKey]
+	if !ok {
+		chats = make(map[string][]unreadMessage)
+		unreadMessages[instanceKey] = chats
+	}
+
+	key := unreadChatKey(evt.Info.MessageSource)
+	pending := append(chats[key], unreadMessage{
+		ID:        evt.Info.ID,
+		Chat:      evt.Info.Chat,
+		Sender:    evt.Info.Sender,
+		Timestamp: evt.Info.Timestamp,
+		Key:       key,
+	})
+	if len(pending) > maxUnreadPerChat {
+		pending = pending[len(pending)-maxUnreadPerChat:]
+	}
+	chats[key] = pending
+}
+
+// ForgetUnreadMessages drops all tracked unread messages for an instance
+func ForgetUnreadMessages(instanceKey string) {
+	unreadMutex.Lock()
+	delete(unreadMessages, instanceKey)
+	unreadMutex.Unlock()
+}
+
+// MarkMessagesRead sends read receipts for the given message IDs in a chat.
+// The sender of each message is taken from the unread tracker, falling back
+// to the given sender for messages that aren't tracked. Returns the IDs that
+// were marked as read.
+func MarkMessagesRead(inst *types.Instance, chat whatsappTypes.JID, ids []string, sender whatsappTypes.JID) ([]string, error) {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	toMark := takeUnread(inst.ID, chat, func(msg unreadMessage) bool {
+		if wanted[msg.ID] {
+			delete(wanted, msg.ID)
+			return true
+		}
+		return false
+	})
+
+	for id := range wanted {
+		if chat.Server == whatsappTypes.GroupServer && sender.IsEmpty() {
+			return nil, fmt.Errorf("sender is required to mark untracked message %s in a group as read", id)
+		}
+		toMark = append(toMark, unreadMessage{ID: id, Chat: chat, Sender: sender, Timestamp: time.Now()})
+	}
+
+	return sendReadReceipts(inst, toMark)
+}
+
+// MarkChatRead sends read receipts for every tracked unread message in a
+// chat that was received at or before upTo. Returns the IDs that were marked.
+func MarkChatRead(inst *types.Instance, chat whatsappTypes.JID, upTo time.Time) ([]string, error) {
+	toMark := takeUnread(inst.ID, chat, func(msg unreadMessage) bool {
+		return !msg.Timestamp.After(upTo)
+	})
+	return sendReadReceipts(inst, toMark)
+}
+
+// MarkEventRead immediately sends a read receipt for an inbound message
+func MarkEventRead(inst *types.Instance, evt *events.Message) error {
+	if evt.Info.IsFromMe {
+		return nil
+	}
+	return inst.Client.MarkRead([]whatsappTypes.MessageID{evt.Info.ID}, time.Now(), evt.Info.Chat, evt.Info.Sender)
+}
+
+// takeUnread removes and returns the tracked unread messages of a chat that match the filter
+func takeUnread(instanceKey string, chat whatsappTypes.JID, match func(unreadMessage) bool) []unreadMessage {
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+
+	chats := unreadMessages[instanceKey]
+	key := chat.ToNonAD().String()
+
+	var taken, kept []unreadMessage
+	for _, msg := range chats[key] {
+		if match(msg) {
+			taken = append(taken, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	if chats != nil {
+		if len(kept) == 0 {
+			delete(chats, key)
+		} else {
+			chats[key] = kept
+		}
+	}
+	return taken
+}
+
+// restoreUnread puts messages that couldn't be marked as read back into the
+// tracker, each under the key it was taken from
+func restoreUnread(instanceKey string, messages []unreadMessage) {
+	restored := make(map[string][]unreadMessage)
+	for _, msg := range messages {
+		if msg.Key != "" {
+			restored[msg.Key] = append(restored[msg.Key], msg)
+		}
+	}
+	if len(restored) == 0 {
+		return
+	}
+
+	unreadMutex.Lock()
+	defer unreadMutex.Unlock()
+
+	chats, ok := unreadMessages[instanceKey]
+	if !ok {
+		chats = make(map[string][]unreadMessage)
+		unreadMessages[instanceKey] = chats
+	}
+	for key, msgs := range restored {
+		pending := append(msgs, chats[key]...)
+		if len(pending) > maxUnreadPerChat {
+			pending = pending[len(pending)-maxUnreadPerChat:]
+		}
+		chats[key] = pending
+	}
+}
+
+// sendReadReceipts marks messages as read, batching them per chat and sender
+// since MarkRead only accepts messages from a single sender at a time
+func sendReadReceipts(inst *types.Instance, messages []unreadMessage) ([]string, error) {
+	type batchKey struct {
+		chat   string
+		sender string
+	}
+	batches := make(map[batchKey][]unreadMessage)
+	var order []batchKey
+	for _, msg := range messages {
+		key := batchKey{chat: msg.Chat.String(), sender: msg.Sender.ToNonAD().String()}
+		if _, ok := batches[key]; !ok {
+			order = append(order, key)
+		}
+		batches[key] = append(batches[key], msg)
+	}
+
+	marked := make([]string, 0, len(messages))
+	for i, key := range order {
+		batch := batches[key]
+		ids := make([]whatsappTypes.MessageID, len(batch))
+		for i, msg := range batch {
+			ids[i] = msg.ID
+		}
+		if err := inst.Client.MarkRead(ids, time.Now(), batch[0].Chat, batch[0].Sender); err != nil {
+			log.Printf("Error marking messages as read in %s: %v", key.chat, err)
+			var remaining []unreadMessage
+			for _, rest := range order[i:] {
+				remaining = append(remaining, batches[rest]...)
+			}
+			restoreUnread(inst.ID, remaining)
+			return marked, fmt.Errorf("failed to mark messages as read: %v", err)
+		}
+		marked = append(marked, ids...)
+	}
+	return marked, nil
+}
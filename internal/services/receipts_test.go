@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestRestoreUnreadUsesTrackedKey(t *testing.T) {
+	const instanceKey = "receipts-test"
+	t.Cleanup(func() { ForgetUnreadMessages(instanceKey) })
+
+	lid := whatsappTypes.NewJID("123456789", whatsappTypes.HiddenUserServer)
+	phone := whatsappTypes.NewJID("5511912345678", whatsappTypes.DefaultUserServer)
+	TrackUnreadMessage(instanceKey, &events.Message{Info: whatsappTypes.MessageInfo{
+		MessageSource: whatsappTypes.MessageSource{Chat: lid, Sender: lid, SenderAlt: phone},
+		ID:            "MSG1",
+		Timestamp:     time.Now(),
+	}})
+
+	// The LID chat is tracked under the phone number, as ResolveChatJID returns it
+	taken := takeUnread(instanceKey, phone, func(unreadMessage) bool { return true })
+	if len(taken) != 1 {
+		t.Fatalf("takeUnread() = %v, want the tracked message", taken)
+	}
+
+	untracked := unreadMessage{ID: "MSG2", Chat: phone, Sender: phone, Timestamp: time.Now()}
+	restoreUnread(instanceKey, append(taken, untracked))
+
+	chats := unreadMessages[instanceKey]
+	if len(chats) != 1 || len(chats[phone.String()]) != 1 || chats[phone.String()][0].ID != "MSG1" {
+		t.Errorf("tracker after restoreUnread = %v, want MSG1 under %s", chats, phone)
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+)
+
+// ErrUnsupportedRecipient is returned for JIDs messages can't be sent to
+var ErrUnsupportedRecipient = errors.New("unsupported recipient")
+
+// Recipient returns who a send request goes to, "to" takes precedence over "phone"
+func Recipient(to, phone string) string {
+	if to != "" {
+		return to
+	}
+	return phone
+}
+
+// ResolveChatJID resolves a recipient into a JID. Each JID server type has its
+// own path: phone numbers go through ValidateAndCorrectPhone, LIDs are mapped
+// to their phone number when known, and group and newsletter JIDs are used
+// as-is since they can't be checked with IsOnWhatsApp.
+func ResolveChatJID(chat string, instance *types.Instance) (whatsappTypes.JID, error) {
+	chat = strings.TrimSpace(chat)
+	if !strings.Contains(chat, "@") {
+		return resolvePhoneJID(chat, instance)
+	}
+
+	jid, err := whatsappTypes.ParseJID(chat)
+	if err != nil {
+		return whatsappTypes.JID{}, fmt.Errorf("invalid JID: %v", err)
+	}
+	if jid.User == "" {
+		return whatsappTypes.JID{}, fmt.Errorf("invalid JID %s: missing user", chat)
+	}
+
+	switch jid.Server {
+	case whatsappTypes.DefaultUserServer, whatsappTypes.LegacyUserServer:
+		// Messages go to the user, not one of their devices
+		return resolvePhoneJID(jid.User+"@"+whatsappTypes.DefaultUserServer, instance)
+	case whatsappTypes.HiddenUserServer:
+		return resolveLID(jid.ToNonAD(), instance), nil
+	case whatsappTypes.GroupServer:
+		return jid, nil
+	case whatsappTypes.NewsletterServer:
+		return jid, nil
+	case whatsappTypes.BroadcastServer:
+		if jid == whatsappTypes.StatusBroadcastJID {
+			return whatsappTypes.JID{}, fmt.Errorf("%w: use the /status endpoints to post statuses", ErrUnsupportedRecipient)
+		}
+		return whatsappTypes.JID{}, fmt.Errorf("%w: %v", ErrUnsupportedRecipient, whatsmeow.ErrBroadcastListUnsupported)
+	default:
+		return whatsappTypes.JID{}, fmt.Errorf("%w: @%s JIDs", ErrUnsupportedRecipient, jid.Server)
+	}
+}
+
+// resolvePhoneJID validates and corrects a phone number and parses it into a JID
+func resolvePhoneJID(phone string, instance *types.Instance) (whatsappTypes.JID, error) {
+	validPhone, err := ValidateAndCorrectPhone(phone, instance)
+	if err != nil {
+		return whatsappTypes.JID{}, err
+	}
+	return whatsappTypes.ParseJID(validPhone)
+}
+
+// UploadMedia uploads the media of a message to recipient. Newsletter media
+// isn't encrypted and is uploaded differently, the handle in the response has
+// to be passed to SendMessage as the MediaHandle.
+func UploadMedia(inst *types.Instance, recipient whatsappTypes.JID, data []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	if recipient.Server == whatsappTypes.NewsletterServer {
+		return inst.Client.UploadNewsletter(context.Background(), data, mediaType)
+	}
+	return inst.Client.Upload(context.Background(), data, mediaType)
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrence computes the occurrences of a repeating schedule
+type recurrence interface {
+	// next returns the first occurrence strictly after `after`, or false when
+	// the schedule has ended. start is the first possible occurrence, its
+	// location is the schedule's time zone.
+	next(start, after time.Time) (time.Time, bool)
+}
+
+// maxRecurrencePeriods bounds the search for the next occurrence, so rules
+// that can never match (e.g. February 30th) don't loop forever
+const maxRecurrencePeriods = 2000
+
+// parseRecurrence parses either an iCalendar RRULE ("FREQ=WEEKLY;BYDAY=MO,WE"
+// with or without the "RRULE:" prefix) or a 5 field cron expression
+// ("0 9 * * 1-5", or one of the @daily style macros)
+func parseRecurrence(spec string) (recurrence, error) {
+	spec = strings.TrimSpace(spec)
+	upper := strings.ToUpper(spec)
+	if strings.HasPrefix(upper, "RRULE:") || strings.Contains(upper, "FREQ=") {
+		return parseRRule(strings.TrimPrefix(upper, "RRULE:"))
+	}
+	return parseCron(spec)
+}
+
+// cronSchedule is a parsed 5 field cron expression, each field a bitset of allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var cronDayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", spec)
+	}
+
+	schedule := &cronSchedule{}
+	var err error
+	if schedule.minute, _, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if schedule.hour, _, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if schedule.dom, schedule.domStar, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if schedule.month, _, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	if schedule.dow, schedule.dowStar, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	// Both 0 and 7 mean Sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps
+// into a bitset. The returned bool reports whether the field was "*".
+func parseCronField(field string, min, max int, names map[string]int) (uint64, bool, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid cron step %q", part)
+			}
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = cronValue(lowPart, min, max, names); err != nil {
+				return 0, false, err
+			}
+			high = low
+			if isRange {
+				if high, err = cronValue(highPart, min, max, names); err != nil {
+					return 0, false, err
+				}
+			} else if hasStep {
+				high = max
+			}
+			if high < low {
+				return 0, false, fmt.Errorf("invalid cron range %q", part)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, field == "*", nil
+}
+
+func cronValue(value string, min, max int, names map[string]int) (int, error) {
+	if named, ok := names[strings.ToUpper(value)]; ok {
+		return named, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid cron value %q", value)
+	}
+	return number, nil
+}
+
+func (s *cronSchedule) next(start, after time.Time) (time.Time, bool) {
+	if after.Before(start) {
+		after = start.Add(-time.Nanosecond)
+	}
+	loc := start.Location()
+	t := after.In(loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dayMatches applies the cron rule that a day matches if either the day of
+// month or the day of week matches, unless one of them is "*"
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// rrule is the subset of RFC 5545 recurrence rules useful for reminders
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	untilLocal bool // UNTIL without "Z", a wall clock time in the schedule's time zone
+	byDay      []rruleDay
+	byMonthDay []int
+	byMonth    []int
+	byHour     []int
+	byMinute   []int
+}
+
+// rruleDay is a BYDAY entry, n is the optional ordinal (1MO, -1FR) used with MONTHLY and YEARLY
+type rruleDay struct {
+	n       int
+	weekday time.Weekday
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRRule(spec string) (*rrule, error) {
+	rule := &rrule{interval: 1}
+	for _, part := range strings.Split(spec, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid RRULE part %q", part)
+		}
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case "HOURLY", "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rule.freq = value
+			default:
+				return nil, fmt.Errorf("unsupported RRULE frequency %q", value)
+			}
+		case "INTERVAL":
+			if rule.interval, err = strconv.Atoi(value); err != nil || rule.interval <= 0 {
+				return nil, fmt.Errorf("invalid RRULE interval %q", value)
+			}
+		case "COUNT":
+			if rule.count, err = strconv.Atoi(value); err != nil || rule.count <= 0 {
+				return nil, fmt.Errorf("invalid RRULE count %q", value)
+			}
+		case "UNTIL":
+			if rule.until, rule.untilLocal, err = parseRRuleTime(value); err != nil {
+				return nil, err
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, known := rruleWeekdays[day[max(len(day)-2, 0):]]
+				if !known {
+					return nil, fmt.Errorf("invalid RRULE day %q", day)
+				}
+				n := 0
+				if ordinal := day[:len(day)-2]; ordinal != "" {
+					if n, err = strconv.Atoi(ordinal); err != nil || n == 0 || n < -5 || n > 5 {
+						return nil, fmt.Errorf("invalid RRULE day %q", day)
+					}
+				}
+				rule.byDay = append(rule.byDay, rruleDay{n: n, weekday: weekday})
+			}
+		case "BYMONTHDAY":
+			if rule.byMonthDay, err = parseRRuleInts(value, -31, 31); err != nil {
+				return nil, err
+			}
+		case "BYMONTH":
+			if rule.byMonth, err = parseRRuleInts(value, 1, 12); err != nil {
+				return nil, err
+			}
+		case "BYHOUR":
+			if rule.byHour, err = parseRRuleInts(value, 0, 23); err != nil {
+				return nil, err
+			}
+		case "BYMINUTE":
+			if rule.byMinute, err = parseRRuleInts(value, 0, 59); err != nil {
+				return nil, err
+			}
+		case "WKST":
+			// Weeks always start on Monday
+		default:
+			return nil, fmt.Errorf("unsupported RRULE part %q", key)
+		}
+	}
+	if rule.freq == "" {
+		return nil, fmt.Errorf("RRULE needs a FREQ")
+	}
+	if rule.count > 0 && !rule.until.IsZero() {
+		return nil, fmt.Errorf("RRULE can't have both COUNT and UNTIL")
+	}
+	return rule, nil
+}
+
+func parseRRuleInts(value string, min, max int) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(value, ",") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < min || number > max || number == 0 && min < 0 {
+			return nil, fmt.Errorf("invalid RRULE value %q", part)
+		}
+		values = append(values, number)
+	}
+	return values, nil
+}
+
+// parseRRuleTime parses an UNTIL value and reports whether it is a local
+// time. A date without time includes the whole day.
+func parseRRuleTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("20060102T150405", value); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t.Add(24*time.Hour - time.Second), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid RRULE until %q", value)
+}
+
+func (r *rrule) next(start, after time.Time) (time.Time, bool) {
+	if r.count > 0 {
+		return r.nextCounted(start, after)
+	}
+	until := r.until
+	if r.untilLocal {
+		until = time.Date(until.Year(), until.Month(), until.Day(), until.Hour(), until.Minute(), until.Second(), 0, start.Location())
+	}
+
+	// Jump close to `after` instead of walking every period since start
+	period := 0
+	if after.After(start) {
+		period = r.periodsBetween(start, after)/r.interval - 1
+		if period < 0 {
+			period = 0
+		}
+	}
+
+	for i := 0; i < maxRecurrencePeriods; i++ {
+		candidates := r.expand(start, (period+i)*r.interval)
+		for _, candidate := range candidates {
+			if candidate.Before(start) || !candidate.After(after) {
+				continue
+			}
+			if !until.IsZero() && candidate.After(until) {
+				return time.Time{}, false
+			}
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextCounted walks the occurrences from start, since COUNT limits the
+// occurrences of the rule and not the messages sent: occurrences that failed
+// or were missed while the instance was offline count too
+func (r *rrule) nextCounted(start, after time.Time) (time.Time, bool) {
+	generated, emptyPeriods := 0, 0
+	for offset := 0; emptyPeriods < maxRecurrencePeriods; offset += r.interval {
+		emptyPeriods++
+		for _, candidate := range r.expand(start, offset) {
+			if candidate.Before(start) {
+				continue
+			}
+			emptyPeriods = 0
+			generated++
+			if generated > r.count {
+				return time.Time{}, false
+			}
+			if candidate.After(after) {
+				return candidate, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// periodsBetween returns roughly how many FREQ units lie between two times
+func (r *rrule) periodsBetween(start, end time.Time) int {
+	switch r.freq {
+	case "HOURLY":
+		return int(end.Sub(start) / time.Hour)
+	case "DAILY":
+		return int(end.Sub(start) / (24 * time.Hour))
+	case "WEEKLY":
+		return int(end.Sub(start) / (7 * 24 * time.Hour))
+	case "MONTHLY":
+		return (end.Year()-start.Year())*12 + int(end.Month()-start.Month())
+	default:
+		return end.Year() - start.Year()
+	}
+}
+
+// expand returns the sorted occurrences of the offset-th FREQ unit after start
+func (r *rrule) expand(start time.Time, offset int) []time.Time {
+	loc := start.Location()
+	var days []time.Time
+
+	switch r.freq {
+	case "HOURLY":
+		hour := time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+offset, 0, 0, 0, loc)
+		if !r.matchesDay(hour) || !containsInt(r.byHour, hour.Hour(), true) {
+			return nil
+		}
+		var occurrences []time.Time
+		for _, minute := range defaultInts(r.byMinute, start.Minute()) {
+			occurrences = append(occurrences, time.Date(hour.Year(), hour.Month(), hour.Day(), hour.Hour(), minute, start.Second(), 0, loc))
+		}
+		sortTimes(occurrences)
+		return occurrences
+	case "DAILY":
+		day := time.Date(start.Year(), start.Month(), start.Day()+offset, 0, 0, 0, 0, loc)
+		if r.matchesDay(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		weekStart := time.Date(start.Year(), start.Month(), start.Day()-(int(start.Weekday())+6)%7+7*offset, 0, 0, 0, 0, loc)
+		weekdays := []time.Weekday{start.Weekday()}
+		if len(r.byDay) > 0 {
+			weekdays = nil
+			for _, day := range r.byDay {
+				weekdays = append(weekdays, day.weekday)
+			}
+		}
+		for _, weekday := range weekdays {
+			day := weekStart.AddDate(0, 0, (int(weekday)+6)%7)
+			if containsInt(r.byMonth, int(day.Month()), true) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		month := time.Date(start.Year(), start.Month()+time.Month(offset), 1, 0, 0, 0, 0, loc)
+		if containsInt(r.byMonth, int(month.Month()), true) {
+			days = r.monthDays(month, start.Day())
+		}
+	case "YEARLY":
+		year := start.Year() + offset
+		for _, month := range defaultInts(r.byMonth, int(start.Month())) {
+			days = append(days, r.monthDays(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc), start.Day())...)
+		}
+	}
+
+	var occurrences []time.Time
+	for _, day := range days {
+		for _, hour := range defaultInts(r.byHour, start.Hour()) {
+			for _, minute := range defaultInts(r.byMinute, start.Minute()) {
+				occurrences = append(occurrences, time.Date(day.Year(), day.Month(), day.Day(), hour, minute, start.Second(), 0, loc))
+			}
+		}
+	}
+	sortTimes(occurrences)
+	return occurrences
+}
+
+// monthDays returns the days of a month selected by BYMONTHDAY and BYDAY,
+// defaulting to the day of month of the start
+func (r *rrule) monthDays(month time.Time, defaultDay int) []time.Time {
+	daysInMonth := month.AddDate(0, 1, -1).Day()
+	var days []time.Time
+
+	for day := 1; day <= daysInMonth; day++ {
+		date := month.AddDate(0, 0, day-1)
+
+		dayMatch := true
+		if len(r.byMonthDay) > 0 {
+			dayMatch = containsInt(r.byMonthDay, day, false) || containsInt(r.byMonthDay, day-daysInMonth-1, false)
+		} else if len(r.byDay) == 0 {
+			dayMatch = day == defaultDay
+		}
+
+		weekdayMatch := len(r.byDay) == 0
+		for _, byDay := range r.byDay {
+			if byDay.weekday != date.Weekday() {
+				continue
+			}
+			nth, nthFromEnd := (day-1)/7+1, -((daysInMonth-day)/7 + 1)
+			if byDay.n == 0 || byDay.n == nth || byDay.n == nthFromEnd {
+				weekdayMatch = true
+			}
+		}
+
+		if dayMatch && weekdayMatch {
+			days = append(days, date)
+		}
+	}
+	return days
+}
+
+// matchesDay applies the BYDAY, BYMONTHDAY and BYMONTH filters to a day
+func (r *rrule) matchesDay(day time.Time) bool {
+	if !containsInt(r.byMonth, int(day.Month()), true) {
+		return false
+	}
+	if len(r.byMonthDay) > 0 {
+		daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+		if !containsInt(r.byMonthDay, day.Day(), false) && !containsInt(r.byMonthDay, day.Day()-daysInMonth-1, false) {
+			return false
+		}
+	}
+	if len(r.byDay) > 0 {
+		for _, byDay := range r.byDay {
+			if byDay.weekday == day.Weekday() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// containsInt reports whether value is in values, an empty list matches when emptyMatches is set
+func containsInt(values []int, value int, emptyMatches bool) bool {
+	if len(values) == 0 {
+		return emptyMatches
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultInts(values []int, fallback int) []int {
+	if len(values) == 0 {
+		return []int{fallback}
+	}
+	return values
+}
+
+func sortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// occurrences returns up to n occurrences of a schedule the way the scheduler
+// walks it, each one after the previous
+func occurrences(t *testing.T, spec string, start time.Time, n int) []time.Time {
+	t.Helper()
+	rule, err := parseRecurrence(spec)
+	if err != nil {
+		t.Fatalf("parseRecurrence(%q) error = %v", spec, err)
+	}
+
+	var times []time.Time
+	after := start.Add(-time.Nanosecond)
+	for len(times) < n {
+		next, ok := rule.next(start, after)
+		if !ok {
+			break
+		}
+		times = append(times, next)
+		after = next
+	}
+	return times
+}
+
+func utcDate(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  string
+		start time.Time
+		want  []time.Time
+		ends  bool // No occurrence follows want
+	}{
+		{
+			name:  "monthly from the 31st skips shorter months",
+			spec:  "FREQ=MONTHLY",
+			start: utcDate(2025, time.January, 31, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 31, 9, 0),
+				utcDate(2025, time.March, 31, 9, 0),
+				utcDate(2025, time.May, 31, 9, 0),
+				utcDate(2025, time.July, 31, 9, 0),
+			},
+		},
+		{
+			name:  "last Friday of the month",
+			spec:  "RRULE:FREQ=MONTHLY;BYDAY=-1FR",
+			start: utcDate(2025, time.January, 1, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 31, 9, 0),
+				utcDate(2025, time.February, 28, 9, 0),
+				utcDate(2025, time.March, 28, 9, 0),
+				utcDate(2025, time.April, 25, 9, 0),
+			},
+		},
+		{
+			name:  "second Tuesday of the month",
+			spec:  "FREQ=MONTHLY;BYDAY=2TU;BYHOUR=18;BYMINUTE=30",
+			start: utcDate(2025, time.January, 1, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 14, 18, 30),
+				utcDate(2025, time.February, 11, 18, 30),
+				utcDate(2025, time.March, 11, 18, 30),
+			},
+		},
+		{
+			name:  "yearly from February 29th only fires in leap years",
+			spec:  "FREQ=YEARLY",
+			start: utcDate(2024, time.February, 29, 8, 0),
+			want: []time.Time{
+				utcDate(2024, time.February, 29, 8, 0),
+				utcDate(2028, time.February, 29, 8, 0),
+				utcDate(2032, time.February, 29, 8, 0),
+			},
+		},
+		{
+			name:  "every other week on Monday and Wednesday",
+			spec:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+			start: utcDate(2025, time.January, 6, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 6, 9, 0),
+				utcDate(2025, time.January, 8, 9, 0),
+				utcDate(2025, time.January, 20, 9, 0),
+				utcDate(2025, time.January, 22, 9, 0),
+			},
+		},
+		{
+			name:  "count stops the schedule",
+			spec:  "FREQ=DAILY;COUNT=3",
+			start: utcDate(2025, time.January, 1, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 1, 9, 0),
+				utcDate(2025, time.January, 2, 9, 0),
+				utcDate(2025, time.January, 3, 9, 0),
+			},
+			ends: true,
+		},
+		{
+			name:  "until stops the schedule",
+			spec:  "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20250115T235959Z",
+			start: utcDate(2025, time.January, 1, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 1, 9, 0),
+				utcDate(2025, time.January, 6, 9, 0),
+				utcDate(2025, time.January, 8, 9, 0),
+				utcDate(2025, time.January, 13, 9, 0),
+				utcDate(2025, time.January, 15, 9, 0),
+			},
+			ends: true,
+		},
+		{
+			name:  "cron day of month or weekday",
+			spec:  "0 9 1 * MON",
+			start: utcDate(2025, time.January, 1, 0, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 1, 9, 0),
+				utcDate(2025, time.January, 6, 9, 0),
+				utcDate(2025, time.January, 13, 9, 0),
+				utcDate(2025, time.January, 20, 9, 0),
+				utcDate(2025, time.January, 27, 9, 0),
+				utcDate(2025, time.February, 1, 9, 0),
+				utcDate(2025, time.February, 3, 9, 0),
+			},
+		},
+		{
+			name:  "cron weekdays with a star day of month",
+			spec:  "30 8 * * 1-5",
+			start: utcDate(2025, time.January, 3, 9, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 6, 8, 30),
+				utcDate(2025, time.January, 7, 8, 30),
+			},
+		},
+		{
+			name:  "cron February 31st never fires",
+			spec:  "0 9 31 2 *",
+			start: utcDate(2025, time.January, 1, 0, 0),
+			ends:  true,
+		},
+		{
+			name:  "cron month names",
+			spec:  "0 8 1 JAN,JUL *",
+			start: utcDate(2025, time.January, 1, 0, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 1, 8, 0),
+				utcDate(2025, time.July, 1, 8, 0),
+				utcDate(2026, time.January, 1, 8, 0),
+			},
+		},
+		{
+			name:  "cron Sunday as 7",
+			spec:  "0 10 * * 7",
+			start: utcDate(2025, time.January, 1, 0, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 5, 10, 0),
+				utcDate(2025, time.January, 12, 10, 0),
+			},
+		},
+		{
+			name:  "weekly macro",
+			spec:  "@weekly",
+			start: utcDate(2025, time.January, 1, 0, 0),
+			want: []time.Time{
+				utcDate(2025, time.January, 5, 0, 0),
+				utcDate(2025, time.January, 12, 0, 0),
+			},
+		},
+		{
+			name:  "monthly macro",
+			spec:  "@MONTHLY",
+			start: utcDate(2025, time.January, 15, 12, 0),
+			want: []time.Time{
+				utcDate(2025, time.February, 1, 0, 0),
+				utcDate(2025, time.March, 1, 0, 0),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := occurrences(t, test.spec, test.start, len(test.want)+1)
+			if !test.ends && len(got) > len(test.want) {
+				got = got[:len(test.want)]
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d occurrences %v, want %d %v", len(got), got, len(test.want), test.want)
+			}
+			for i := range test.want {
+				if !got[i].Equal(test.want[i]) {
+					t.Errorf("occurrence %d = %v, want %v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecurrenceLocalUntil(t *testing.T) {
+	saoPaulo := time.FixedZone("-03", -3*60*60)
+	start := time.Date(2025, time.January, 1, 9, 0, 0, 0, saoPaulo)
+
+	for _, spec := range []string{
+		"FREQ=DAILY;UNTIL=20250103T090000",
+		"FREQ=DAILY;UNTIL=20250103",
+	} {
+		// 09:00 local is 12:00 UTC, after the UNTIL if it were read as UTC
+		got := occurrences(t, spec, start, 4)
+		if len(got) != 3 || !got[2].Equal(time.Date(2025, time.January, 3, 9, 0, 0, 0, saoPaulo)) {
+			t.Errorf("%s: occurrences = %v, want 3 ending on January 3rd 09:00 -03", spec, got)
+		}
+	}
+
+	// A UTC UNTIL keeps meaning UTC
+	got := occurrences(t, "FREQ=DAILY;UNTIL=20250103T090000Z", start, 4)
+	if len(got) != 2 {
+		t.Errorf("occurrences = %v, want 2", got)
+	}
+}
+
+func TestRecurrenceCountIncludesMissedOccurrences(t *testing.T) {
+	rule, err := parseRecurrence("FREQ=DAILY;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := utcDate(2025, time.January, 1, 9, 0)
+
+	// The first two occurrences were missed, only the third is left
+	next, ok := rule.next(start, utcDate(2025, time.January, 2, 12, 0))
+	if !ok || !next.Equal(utcDate(2025, time.January, 3, 9, 0)) {
+		t.Fatalf("next() = %v, %v, want January 3rd", next, ok)
+	}
+	if next, ok := rule.next(start, next); ok {
+		t.Errorf("next() after the third occurrence = %v, want the schedule to end", next)
+	}
+}
+
+func TestParseRecurrenceErrors(t *testing.T) {
+	for _, spec := range []string{
+		"FREQ=SECONDLY",
+		"FREQ=DAILY;COUNT=2;UNTIL=20250101",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"INTERVAL=2;FREQ=",
+		"0 9 * *",
+		"60 9 * * *",
+		"0 9 * * FUNDAY",
+		"0 9 10-5 * *",
+		"*/0 * * * *",
+	} {
+		if _, err := parseRecurrence(spec); err == nil {
+			t.Errorf("parseRecurrence(%q) succeeded, want an error", spec)
+		}
+	}
+}
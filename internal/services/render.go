@@ -0,0 +1,163 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderRegex matches {{variable}} placeholders in message templates
+var placeholderRegex = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// jsonNumberRegex matches the values a numeric template variable may have
+var jsonNumberRegex = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+var (
+	// ErrMissingTemplateVariables is returned when a template is rendered without all its variables
+	ErrMissingTemplateVariables = errors.New("missing template variables")
+	// ErrInvalidTemplateVariables is returned when a numeric field's variable isn't a number
+	ErrInvalidTemplateVariables = errors.New("invalid template variables")
+)
+
+// templateNumberFields are the numeric fields of each message type. JSON
+// can't hold a placeholder in a number, so they take a string that is a
+// single placeholder ("latitude": "{{lat}}"), rendered as a number.
+var templateNumberFields = map[string][]string{
+	"location": {"latitude", "longitude", "accuracy_in_meters", "live_duration"},
+	"poll":     {"selectable_count"},
+}
+
+// TemplateVariables returns the sorted, unique placeholder names used
+// anywhere in the string values of a JSON message template
+func TemplateVariables(template json.RawMessage) ([]string, error) {
+	var decoded interface{}
+	if err := json.Unmarshal(template, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	walkTemplateStrings(decoded, func(value string) string {
+		for _, match := range placeholderRegex.FindAllStringSubmatch(value, -1) {
+			seen[match[1]] = true
+		}
+		return value
+	})
+
+	variables := make([]string, 0, len(seen))
+	for name := range seen {
+		variables = append(variables, name)
+	}
+	sort.Strings(variables)
+	return variables, nil
+}
+
+// TemplateNumberVariables checks that the numeric fields of a message
+// template of the given type are numbers or a single placeholder, and
+// returns the variables used by them
+func TemplateNumberVariables(template json.RawMessage, messageType string) (map[string]string, error) {
+	var message map[string]interface{}
+	if err := json.Unmarshal(template, &message); err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+
+	variables := make(map[string]string)
+	for _, field := range templateNumberFields[messageType] {
+		value, ok := message[field].(string)
+		if !ok {
+			continue
+		}
+		match := placeholderRegex.FindStringSubmatch(value)
+		if match == nil || match[0] != strings.TrimSpace(value) {
+			return nil, fmt.Errorf("%s must be a number or a single {{variable}}", field)
+		}
+		variables[field] = match[1]
+	}
+	return variables, nil
+}
+
+// RenderTemplate replaces the placeholders in every string value of a JSON
+// message template. Missing variables are an error, so a message never goes
+// out with a raw {{placeholder}} in it. The numeric fields of messageType
+// are rendered as numbers.
+func RenderTemplate(template json.RawMessage, messageType string, variables map[string]string) (json.RawMessage, error) {
+	numberFields, err := TemplateNumberVariables(template, messageType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplateVariables, err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(template, &decoded); err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+
+	var missing []string
+	reported := make(map[string]bool)
+	message, _ := decoded.(map[string]interface{})
+	for field, name := range numberFields {
+		value, ok := variables[name]
+		if !ok {
+			// Reported as missing with the placeholders of the other fields
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if !jsonNumberRegex.MatchString(value) {
+			return nil, fmt.Errorf("%w: %s must be a number for %s, got %q", ErrInvalidTemplateVariables, name, field, value)
+		}
+		message[field] = json.Number(value)
+	}
+	rendered := walkTemplateStrings(decoded, func(value string) string {
+		return placeholderRegex.ReplaceAllStringFunc(value, func(placeholder string) string {
+			name := placeholderRegex.FindStringSubmatch(placeholder)[1]
+			replacement, ok := variables[name]
+			if !ok {
+				if !reported[name] {
+					reported[name] = true
+					missing = append(missing, name)
+				}
+				return placeholder
+			}
+			return replacement
+		})
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s", ErrMissingTemplateVariables, strings.Join(missing, ", "))
+	}
+
+	return json.Marshal(rendered)
+}
+
+// walkTemplateStrings applies fn to every string in a decoded JSON value
+func walkTemplateStrings(value interface{}, fn func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return fn(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = walkTemplateStrings(item, fn)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = walkTemplateStrings(item, fn)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// stripMessageTarget removes the fields that say where and when a message is
+// sent from a message template, they are set when the template is used
+func stripMessageTarget(message map[string]json.RawMessage) {
+	delete(message, "instance_key")
+	delete(message, "phone")
+	delete(message, "to")
+	delete(message, "idempotency_key")
+	delete(message, "custom_message_id")
+	delete(message, "metadata")
+	StripScheduleFields(message)
+	StripQueueFields(message)
+}
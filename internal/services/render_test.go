@@ -0,0 +1,84 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		messageType string
+		variables   map[string]string
+		want        string
+		err         error
+	}{
+		{
+			name:      "text placeholders",
+			template:  `{"message": "Hi {{name}}, your order {{ order }} shipped", "link_preview": true}`,
+			variables: map[string]string{"name": "Ana", "order": "42"},
+			want:      `{"message": "Hi Ana, your order 42 shipped", "link_preview": true}`,
+		},
+		{
+			name:        "numeric fields render as numbers",
+			template:    `{"latitude": "{{lat}}", "longitude": "{{lng}}", "name": "Store {{lat}}", "accuracy_in_meters": 10}`,
+			messageType: "location",
+			variables:   map[string]string{"lat": "-23.5613", "lng": " -46.6565 "},
+			want:        `{"latitude": -23.5613, "longitude": -46.6565, "name": "Store -23.5613", "accuracy_in_meters": 10}`,
+		},
+		{
+			name:        "numeric variable isn't a number",
+			template:    `{"latitude": "{{lat}}", "longitude": 1}`,
+			messageType: "location",
+			variables:   map[string]string{"lat": "south"},
+			err:         ErrInvalidTemplateVariables,
+		},
+		{
+			name:        "numeric field with more than a placeholder",
+			template:    `{"latitude": "{{lat}}0", "longitude": 1}`,
+			messageType: "location",
+			variables:   map[string]string{"lat": "1"},
+			err:         ErrInvalidTemplateVariables,
+		},
+		{
+			name:        "missing variables",
+			template:    `{"latitude": "{{lat}}", "longitude": "{{lng}}", "name": "{{store}}"}`,
+			messageType: "location",
+			variables:   map[string]string{"lat": "1"},
+			err:         ErrMissingTemplateVariables,
+		},
+		{
+			name:        "numeric fields of other types stay strings",
+			template:    `{"message": "{{lat}}", "latitude": "{{lat}}"}`,
+			messageType: "text",
+			variables:   map[string]string{"lat": "1.5"},
+			want:        `{"message": "1.5", "latitude": "1.5"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered, err := RenderTemplate(json.RawMessage(test.template), test.messageType, test.variables)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("RenderTemplate() error = %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderTemplate() error = %v", err)
+			}
+
+			var got, want interface{}
+			json.Unmarshal(rendered, &got)
+			json.Unmarshal([]byte(test.want), &want)
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("RenderTemplate() = %s, want %s", rendered, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"multi-client-whatsapp/internal/instance"
+	"multi-client-whatsapp/internal/platform/database"
+	"multi-client-whatsapp/internal/types"
+
+	"github.com/google/uuid"
+)
+
+// Scheduler tuning
+const (
+	schedulerInterval     = 5 * time.Second
+	schedulerBatchSize    = 50
+	scheduledOfflineRetry = time.Minute     // Delay when the instance isn't connected
+	scheduledErrorRetry   = 2 * time.Minute // Base delay after a failed send, multiplied by the attempt
+	maxScheduledAttempts  = 5
+	// An occurrence waits this many offline retries, an hour, for its instance
+	maxScheduledOfflineRetries = 60
+)
+
+// Scheduled message statuses
+const (
+	ScheduledPending   = "pending"
+	ScheduledSent      = "sent"      // One-off message was sent
+	ScheduledCompleted = "completed" // Recurrence has no more occurrences
+	ScheduledFailed    = "failed"
+	ScheduledCancelled = "cancelled"
+)
+
+// scheduleFields are the request fields that control scheduling, they are
+// stripped from the stored payload so the replayed request sends right away
+var scheduleFields = []string{"send_at", "recurrence", "timezone"}
+
+var (
+	ErrScheduledMessageNotFound   = errors.New("scheduled message not found")
+	ErrScheduledMessageNotPending = errors.New("scheduled message is no longer pending")
+	ErrInvalidSchedule            = errors.New("invalid schedule")
+)
+
+// replayHandler is the HTTP handler scheduled and queued messages are
+// replayed through, so they go through exactly the same validation and
+// sending code as requests made directly to the send endpoints
+var replayHandler http.Handler
+
+const scheduledMessageColumns = `id, instance_key, endpoint, payload, send_at, starts_at, recurrence, timezone,
+	status, attempts, offline_retries, run_count, last_error, last_message_id, last_queue_id, last_run_at, created_at, updated_at`
+
+// StartScheduler starts sending due scheduled messages in the background
+func StartScheduler(handler http.Handler) {
+	replayHandler = handler
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := runDueMessages(); err != nil {
+				log.Printf("Scheduler: %v", err)
+			}
+		}
+	}()
+	log.Printf("Message scheduler started")
+}
+
+// FirstScheduledRun validates a schedule and returns when it first runs. For
+// one-off messages that's start itself, for recurring ones the first
+// occurrence at or after start.
+func FirstScheduledRun(start time.Time, recurrenceSpec, timezone string) (time.Time, error) {
+	loc, err := time.LoadLocation(firstNonEmpty(timezone, "UTC"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timezone: %s", timezone)
+	}
+	start = start.In(loc)
+	if recurrenceSpec == "" {
+		return start, nil
+	}
+
+	rule, err := parseRecurrence(recurrenceSpec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	first, ok := rule.next(start, start.Add(-time.Nanosecond))
+	if !ok {
+		return time.Time{}, fmt.Errorf("recurrence %q has no occurrences after %s", recurrenceSpec, start.Format(time.RFC3339))
+	}
+	return first, nil
+}
+
+// StripScheduleFields removes the scheduling fields from a send request body
+func StripScheduleFields(body map[string]json.RawMessage) {
+	for _, field := range scheduleFields {
+		delete(body, field)
+	}
+}
+
+// ScheduleMessage stores a send request to be replayed against endpoint at msg.SendAt
+func ScheduleMessage(msg *types.ScheduledMessage) error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	msg.ID = uuid.New().String()
+	msg.Status = ScheduledPending
+	msg.Timezone = firstNonEmpty(msg.Timezone, "UTC")
+	msg.CreatedAt = time.Now()
+	msg.UpdatedAt = msg.CreatedAt
+
+	_, err = db.Exec(`INSERT INTO scheduled_messages
+		(id, instance_key, endpoint, payload, send_at, starts_at, recurrence, timezone, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		msg.ID, msg.InstanceKey, msg.Endpoint, string(msg.Payload), msg.SendAt, msg.StartsAt,
+		msg.Recurrence, msg.Timezone, msg.Status, msg.CreatedAt, msg.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store scheduled message: %w", err)
+	}
+	return nil
+}
+
+// ListScheduledMessages returns the scheduled messages of an instance, optionally filtered by status
+func ListScheduledMessages(instanceKey, status string) ([]types.ScheduledMessage, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+		WHERE instance_key = $1 AND ($2 = '' OR status = $2)
+		ORDER BY send_at`, instanceKey, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []types.ScheduledMessage{}
+	for rows.Next() {
+		msg, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, *msg)
+	}
+	return messages, rows.Err()
+}
+
+// GetScheduledMessage returns a single scheduled message of an instance
+func GetScheduledMessage(instanceKey, id string) (*types.ScheduledMessage, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	row := db.QueryRow(`SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+		WHERE instance_key = $1 AND id = $2`, instanceKey, id)
+	msg, err := scanScheduledMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrScheduledMessageNotFound
+	}
+	return msg, err
+}
+
+// UpdateScheduledMessage changes the time, recurrence or content of a pending scheduled message
+func UpdateScheduledMessage(instanceKey, id string, req *types.UpdateScheduledMessageRequest) (*types.ScheduledMessage, error) {
+	msg, err := GetScheduledMessage(instanceKey, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status != ScheduledPending {
+		return nil, ErrScheduledMessageNotPending
+	}
+
+	if req.Payload != nil {
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(req.Payload, &body); err != nil {
+			return nil, fmt.Errorf("%w: payload must be a JSON object", ErrInvalidSchedule)
+		}
+		StripScheduleFields(body)
+		// The message can't be moved to another instance
+		body["instance_key"], _ = json.Marshal(instanceKey)
+		if msg.Payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.SendAt != nil || req.Recurrence != nil || req.Timezone != nil {
+		if req.SendAt != nil {
+			msg.StartsAt = *req.SendAt
+		}
+		if req.Recurrence != nil {
+			msg.Recurrence = *req.Recurrence
+		}
+		if req.Timezone != nil {
+			msg.Timezone = firstNonEmpty(*req.Timezone, "UTC")
+		}
+
+		start := msg.StartsAt
+		if req.SendAt == nil && msg.Recurrence != "" && start.Before(time.Now()) {
+			// Don't replay occurrences that already passed
+			start = time.Now()
+		}
+		if msg.SendAt, err = FirstScheduledRun(start, msg.Recurrence, msg.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+		}
+		msg.Attempts = 0
+		msg.OfflineRetries = 0
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+	msg.UpdatedAt = time.Now()
+	result, err := db.Exec(`UPDATE scheduled_messages
+		SET payload = $3, send_at = $4, starts_at = $5, recurrence = $6, timezone = $7, attempts = $8,
+			offline_retries = $9, updated_at = $10
+		WHERE instance_key = $1 AND id = $2 AND status = 'pending'`,
+		instanceKey, id, string(msg.Payload), msg.SendAt, msg.StartsAt, msg.Recurrence, msg.Timezone, msg.Attempts,
+		msg.OfflineRetries, msg.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update scheduled message: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		// Sent or cancelled while we were updating it
+		return nil, ErrScheduledMessageNotPending
+	}
+	return msg, nil
+}
+
+// CancelScheduledMessage stops a pending scheduled message from being sent
+func CancelScheduledMessage(instanceKey, id string) (*types.ScheduledMessage, error) {
+	msg, err := GetScheduledMessage(instanceKey, id)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Status != ScheduledPending {
+		return nil, ErrScheduledMessageNotPending
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+	msg.Status = ScheduledCancelled
+	msg.UpdatedAt = time.Now()
+	result, err := db.Exec(`UPDATE scheduled_messages SET status = $3, updated_at = $4
+		WHERE instance_key = $1 AND id = $2 AND status = 'pending'`,
+		instanceKey, id, msg.Status, msg.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel scheduled message: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrScheduledMessageNotPending
+	}
+	return msg, nil
+}
+
+// DeleteScheduledMessages removes all scheduled messages of a deleted instance
+func DeleteScheduledMessages(instanceKey string) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		log.Printf("Warning: Error deleting scheduled messages of instance %s: %v", instanceKey, err)
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM scheduled_messages WHERE instance_key = $1`, instanceKey); err != nil {
+		log.Printf("Warning: Error deleting scheduled messages of instance %s: %v", instanceKey, err)
+	}
+}
+
+// runDueMessages sends every pending message whose time has come
+func runDueMessages() error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+		WHERE status = 'pending' AND send_at <= now()
+		ORDER BY send_at LIMIT $1`, schedulerBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to load due messages: %w", err)
+	}
+	var due []*types.ScheduledMessage
+	for rows.Next() {
+		msg, err := scanScheduledMessage(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, msg)
+	}
+	rows.Close()
+
+	for _, msg := range due {
+		runScheduledMessage(db, msg)
+	}
+	return nil
+}
+
+// runScheduledMessage sends one due message and moves it to its next state
+func runScheduledMessage(db *sql.DB, msg *types.ScheduledMessage) {
+	now := time.Now()
+
+	if !instanceExists(msg.InstanceKey) {
+		// Instances aren't restored after a restart and their keys aren't
+		// reused, so the message can never be sent
+		msg.Status = ScheduledFailed
+		msg.LastError = "instance not found"
+		saveScheduledRun(db, msg)
+		return
+	}
+	if !instanceConnected(msg.InstanceKey) {
+		waitForInstance(msg, now)
+		saveScheduledRun(db, msg)
+		return
+	}
+
+	messageID, queueID, status, notSent, err := dispatchScheduledMessage(msg)
+	msg.LastRunAt = &now
+	if err == nil {
+		log.Printf("Scheduler: handed scheduled message %s of instance %s to the outbound queue", msg.ID, msg.InstanceKey)
+		msg.RunCount++
+		msg.Attempts = 0
+		msg.OfflineRetries = 0
+		msg.LastError = ""
+		msg.LastMessageID = messageID
+		msg.LastQueueID = queueID
+		advanceSchedule(msg, now, ScheduledSent)
+		saveScheduledRun(db, msg)
+		return
+	}
+
+	log.Printf("Scheduler: failed to send scheduled message %s of instance %s: %v", msg.ID, msg.InstanceKey, err)
+	msg.LastError = err.Error()
+	msg.Attempts++
+	switch {
+	case status == http.StatusServiceUnavailable && notSent:
+		// Disconnected between our check and the send
+		msg.Attempts--
+		waitForInstance(msg, now)
+	case status >= 500 && notSent && msg.Attempts < maxScheduledAttempts:
+		// Other server errors may have sent the message anyway, they aren't retried
+		msg.SendAt = now.Add(time.Duration(msg.Attempts) * scheduledErrorRetry)
+	default:
+		// The request itself is invalid or kept failing. A recurring message
+		// skips this occurrence, a one-off message gives up.
+		msg.Attempts = 0
+		msg.OfflineRetries = 0
+		advanceSchedule(msg, now, ScheduledFailed)
+	}
+	saveScheduledRun(db, msg)
+}
+
+// waitForInstance keeps a message pending until its instance is connected
+// again. After maxScheduledOfflineRetries a recurring message skips the
+// occurrence and a one-off message fails.
+func waitForInstance(msg *types.ScheduledMessage, now time.Time) {
+	msg.OfflineRetries++
+	if msg.OfflineRetries > maxScheduledOfflineRetries {
+		msg.OfflineRetries = 0
+		msg.LastError = fmt.Sprintf("instance was not connected for %s", maxScheduledOfflineRetries*scheduledOfflineRetry)
+		advanceSchedule(msg, now, ScheduledFailed)
+		return
+	}
+	msg.SendAt = now.Add(scheduledOfflineRetry)
+	msg.LastError = "instance is not connected"
+}
+
+// advanceSchedule moves a recurring message to its next occurrence, or marks
+// the message as done with the given final status
+func advanceSchedule(msg *types.ScheduledMessage, now time.Time, final string) {
+	if msg.Recurrence == "" {
+		msg.Status = final
+		return
+	}
+
+	rule, err := parseRecurrence(msg.Recurrence)
+	if err != nil {
+		msg.Status = ScheduledFailed
+		msg.LastError = err.Error()
+		return
+	}
+	loc, err := time.LoadLocation(msg.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	next, ok := rule.next(msg.StartsAt.In(loc), now)
+	if !ok {
+		msg.Status = ScheduledCompleted
+		return
+	}
+	msg.SendAt = next
+}
+
+// dispatchScheduledMessage hands the stored request to the outbound queue,
+// so scheduled messages are paced like any other send. It returns the message
+// ID (when the queue is unavailable and the message was sent directly) or the
+// queue ID, otherwise the response status, whether the failure happened
+// before anything was handed to WhatsApp, and the error.
+func dispatchScheduledMessage(msg *types.ScheduledMessage) (string, string, int, bool, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Payload, &body); err != nil {
+		return "", "", 400, false, fmt.Errorf("invalid payload: %v", err)
+	}
+	body["async"] = json.RawMessage("true")
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", "", 400, false, err
+	}
+
+	code, respBody := replaySendRequest(context.Background(), msg.Endpoint, payload)
+
+	var resp struct {
+		MessageID string `json:"message_id"`
+		QueueID   string `json:"queue_id"`
+		Error     string `json:"error"`
+	}
+	json.Unmarshal(respBody, &resp)
+
+	if code != http.StatusOK && code != http.StatusAccepted {
+		return "", "", code, SendNotAttempted(respBody), fmt.Errorf("%d: %s", code, firstNonEmpty(resp.Error, string(respBody)))
+	}
+	return resp.MessageID, resp.QueueID, code, false, nil
+}
+
+// saveScheduledRun persists the outcome of a run, unless the message was cancelled meanwhile
+func saveScheduledRun(db *sql.DB, msg *types.ScheduledMessage) {
+	msg.UpdatedAt = time.Now()
+	_, err := db.Exec(`UPDATE scheduled_messages
+		SET send_at = $2, status = $3, attempts = $4, offline_retries = $5, run_count = $6, last_error = $7,
+			last_message_id = $8, last_queue_id = $9, last_run_at = $10, updated_at = $11
+		WHERE id = $1 AND status = 'pending'`,
+		msg.ID, msg.SendAt, msg.Status, msg.Attempts, msg.OfflineRetries, msg.RunCount, msg.LastError,
+		msg.LastMessageID, msg.LastQueueID, msg.LastRunAt, msg.UpdatedAt)
+	if err != nil {
+		log.Printf("Scheduler: failed to update scheduled message %s: %v", msg.ID, err)
+	}
+}
+
+// instanceExists reports whether an instance is loaded
+func instanceExists(instanceKey string) bool {
+	instance.Manager.Mutex.RLock()
+	defer instance.Manager.Mutex.RUnlock()
+	_, exists := instance.Manager.Instances[instanceKey]
+	return exists
+}
+
+// instanceConnected reports whether an instance is loaded and connected
+func instanceConnected(instanceKey string) bool {
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	inst.Mutex.RLock()
+	defer inst.Mutex.RUnlock()
+	return inst.IsConnected && inst.Client != nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanScheduledMessage(row rowScanner) (*types.ScheduledMessage, error) {
+	msg := &types.ScheduledMessage{}
+	var payload []byte
+	var lastRunAt sql.NullTime
+	err := row.Scan(&msg.ID, &msg.InstanceKey, &msg.Endpoint, &payload, &msg.SendAt, &msg.StartsAt,
+		&msg.Recurrence, &msg.Timezone, &msg.Status, &msg.Attempts, &msg.OfflineRetries, &msg.RunCount, &msg.LastError,
+		&msg.LastMessageID, &msg.LastQueueID, &lastRunAt, &msg.CreatedAt, &msg.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = payload
+	if lastRunAt.Valid {
+		msg.LastRunAt = &lastRunAt.Time
+	}
+	return msg, nil
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrStatusAudienceUnsupported is returned for status posts with an audience
+// list. whatsmeow always sends statuses to the recipients allowed by the
+// account's status privacy setting and can't target a custom list.
+var ErrStatusAudienceUnsupported = errors.New("custom status audiences are not supported, statuses go to the recipients allowed by the account's status privacy setting")
+
+// IsStatusMessage checks whether a message event is a status post
+func IsStatusMessage(evt *events.Message) bool {
+	return evt.Info.Chat == whatsappTypes.StatusBroadcastJID
+}
+
+// BuildTextStatus creates the message for a text status
+func BuildTextStatus(req *types.TextStatusRequest) (*waE2E.Message, error) {
+	text := &waE2E.ExtendedTextMessage{
+		Text: proto.String(req.Text),
+	}
+
+	if req.BackgroundColor != "" {
+		color, err := parseStatusColor(req.BackgroundColor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid background_color: %v", err)
+		}
+		text.BackgroundArgb = proto.Uint32(color)
+	}
+	if req.TextColor != "" {
+		color, err := parseStatusColor(req.TextColor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid text_color: %v", err)
+		}
+		text.TextArgb = proto.Uint32(color)
+	}
+	if req.Font != "" {
+		font, ok := waE2E.ExtendedTextMessage_FontType_value[strings.ToUpper(req.Font)]
+		if !ok {
+			return nil, fmt.Errorf("invalid font %q", req.Font)
+		}
+		text.Font = waE2E.ExtendedTextMessage_FontType(font).Enum()
+	}
+
+	return &waE2E.Message{ExtendedTextMessage: text}, nil
+}
+
+// ParseTextStatus returns the text, colours and font of a text status post,
+// or nil if the event isn't one
+func ParseTextStatus(evt *events.Message) *types.TextStatus {
+	if !IsStatusMessage(evt) {
+		return nil
+	}
+	text := evt.Message.GetExtendedTextMessage()
+	if text == nil && evt.Message.GetConversation() == "" {
+		return nil
+	}
+
+	status := &types.TextStatus{
+		Text: evt.Message.GetConversation(),
+	}
+	if text != nil {
+		status.Text = text.GetText()
+		if text.BackgroundArgb != nil {
+			status.BackgroundColor = formatStatusColor(text.GetBackgroundArgb())
+		}
+		if text.TextArgb != nil {
+			status.TextColor = formatStatusColor(text.GetTextArgb())
+		}
+		if text.Font != nil {
+			status.Font = strings.ToLower(text.GetFont().String())
+		}
+	}
+	return status
+}
+
+// parseStatusColor parses a #RRGGBB or #AARRGGBB colour, colours without
+// alpha are opaque
+func parseStatusColor(value string) (uint32, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return 0, fmt.Errorf("%q must be #RRGGBB or #AARRGGBB", value)
+	}
+	color, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q must be #RRGGBB or #AARRGGBB", value)
+	}
+	if len(hex) == 6 {
+		color |= 0xFF000000
+	}
+	return uint32(color), nil
+}
+
+// formatStatusColor formats an ARGB colour as #AARRGGBB
+func formatStatusColor(color uint32) string {
+	return fmt.Sprintf("#%08X", color)
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+
+	"multi-client-whatsapp/internal/platform/database"
+	"multi-client-whatsapp/internal/types"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrTemplateExists   = errors.New("template already exists")
+	ErrInvalidTemplate  = errors.New("invalid template")
+)
+
+// templateNameRegex limits template names to something safe in URLs
+var templateNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+
+// templateRequiredFields are the fields a template of each type must set,
+// any one of a group of alternatives is enough
+var templateRequiredFields = map[string][][]string{
+	"text":     {{"message"}},
+	"media":    {{"url"}, {"type"}},
+	"contact":  {{"contacts", "contact_name"}},
+	"location": {{"latitude"}, {"longitude"}},
+}
+
+const templateColumns = `name, version, message_type, message, variables, description, created_at`
+
+// CreateTemplate stores the first version of a new template
+func CreateTemplate(req *types.TemplateRequest) (*types.MessageTemplate, error) {
+	if !templateNameRegex.MatchString(req.Name) {
+		return nil, fmt.Errorf("%w: name must be 1 to 100 letters, digits, '_', '.' or '-'", ErrInvalidTemplate)
+	}
+	if _, err := GetTemplate(req.Name, 0); err == nil {
+		return nil, ErrTemplateExists
+	} else if !errors.Is(err, ErrTemplateNotFound) {
+		return nil, err
+	}
+	return saveTemplate(req, 1)
+}
+
+// AddTemplateVersion stores a new version of an existing template. Earlier
+// versions are kept, so messages pinned to them still render the same.
+func AddTemplateVersion(req *types.TemplateRequest) (*types.MessageTemplate, error) {
+	latest, err := GetTemplate(req.Name, 0)
+	if err != nil {
+		return nil, err
+	}
+	return saveTemplate(req, latest.Version+1)
+}
+
+// GetTemplate returns a version of a template, or its latest version when version is 0
+func GetTemplate(name string, version int) (*types.MessageTemplate, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := scanTemplate(db.QueryRow(`SELECT `+templateColumns+` FROM message_templates
+		WHERE name = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC LIMIT 1`, name, version))
+	if errors.Is(err, sql.ErrNoRows) {
+		if version != 0 {
+			return nil, fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, name, version)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+	return template, nil
+}
+
+// ListTemplates returns the latest version of every template
+func ListTemplates() ([]types.MessageTemplate, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT DISTINCT ON (name) ` + templateColumns + ` FROM message_templates
+		ORDER BY name, version DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	return scanTemplates(rows)
+}
+
+// ListTemplateVersions returns all versions of a template, newest first
+func ListTemplateVersions(name string) ([]types.MessageTemplate, error) {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT `+templateColumns+` FROM message_templates
+		WHERE name = $1 ORDER BY version DESC`, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list template versions: %w", err)
+	}
+	templates, err := scanTemplates(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return templates, nil
+}
+
+// DeleteTemplate removes a template, or only one of its versions when version isn't 0
+func DeleteTemplate(name string, version int) error {
+	db, err := database.BridgeDB()
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`DELETE FROM message_templates WHERE name = $1 AND ($2 = 0 OR version = $2)`, name, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	log.Printf("Template %s deleted", name)
+	return nil
+}
+
+// RenderMessageTemplate loads a template and fills in its variables. It
+// returns the template and the rendered body for its send endpoint.
+func RenderMessageTemplate(name string, version int, variables map[string]string) (*types.MessageTemplate, json.RawMessage, error) {
+	template, err := GetTemplate(name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rendered, err := RenderTemplate(template.Message, template.Type, variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	return template, rendered, nil
+}
+
+// saveTemplate validates a template request and stores it as the given version
+func saveTemplate(req *types.TemplateRequest, version int) (*types.MessageTemplate, error) {
+	required, ok := templateRequiredFields[req.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: type must be one of: text, media, contact, location", ErrInvalidTemplate)
+	}
+
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(req.Message, &message); err != nil || message == nil {
+		return nil, fmt.Errorf("%w: message must be a JSON object", ErrInvalidTemplate)
+	}
+	stripMessageTarget(message)
+	delete(message, "reply_to")
+	for _, alternatives := range required {
+		found := false
+		for _, field := range alternatives {
+			if _, ok := message[field]; ok {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s message needs %q", ErrInvalidTemplate, req.Type, alternatives[0])
+		}
+	}
+
+	template := &types.MessageTemplate{
+		Name:        req.Name,
+		Version:     version,
+		Type:        req.Type,
+		Description: req.Description,
+	}
+	var err error
+	if template.Message, err = json.Marshal(message); err != nil {
+		return nil, err
+	}
+	if template.Variables, err = TemplateVariables(template.Message); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if _, err := TemplateNumberVariables(template.Message, template.Type); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	db, err := database.BridgeDB()
+	if err != nil {
+		return nil, err
+	}
+
+	variables, _ := json.Marshal(template.Variables)
+	err = db.QueryRow(`INSERT INTO message_templates (name, version, message_type, message, variables, description)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`,
+		template.Name, template.Version, template.Type, string(template.Message), string(variables), template.Description).
+		Scan(&template.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		// Another request stored the same version first
+		if version == 1 {
+			return nil, ErrTemplateExists
+		}
+		return nil, fmt.Errorf("%w: version %d of %s was just added, retry", ErrTemplateExists, version, template.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+
+	log.Printf("Template %s version %d saved", template.Name, template.Version)
+	return template, nil
+}
+
+func scanTemplates(rows *sql.Rows) ([]types.MessageTemplate, error) {
+	defer rows.Close()
+
+	templates := []types.MessageTemplate{}
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	return templates, rows.Err()
+}
+
+func scanTemplate(row rowScanner) (*types.MessageTemplate, error) {
+	template := &types.MessageTemplate{}
+	var message, variables []byte
+	err := row.Scan(&template.Name, &template.Version, &template.Type, &message, &variables,
+		&template.Description, &template.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	template.Message = message
+	json.Unmarshal(variables, &template.Variables)
+	return template, nil
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"multi-client-whatsapp/internal/types"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxVCardLineLength is the line length after which vCard lines are folded (RFC 6350 section 3.2)
+const maxVCardLineLength = 75
+
+// vcardEscaper escapes text values as required by RFC 6350 section 3.4
+var vcardEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`,`, `\,`,
+	`;`, `\;`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", `\n`,
+)
+
+// BuildContactsMessage builds a ContactMessage for a single contact or a
+// ContactsArrayMessage when several contacts are sent at once
+func BuildContactsMessage(contacts []types.Contact) (*waE2E.Message, error) {
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("at least one contact is required")
+	}
+
+	messages := make([]*waE2E.ContactMessage, 0, len(contacts))
+	for i := range contacts {
+		vcard, err := BuildVCard(&contacts[i])
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &waE2E.ContactMessage{
+			DisplayName: proto.String(contacts[i].Name),
+			Vcard:       proto.String(vcard),
+		})
+	}
+
+	if len(messages) == 1 {
+		return &waE2E.Message{ContactMessage: messages[0]}, nil
+	}
+	return &waE2E.Message{
+		ContactsArrayMessage: &waE2E.ContactsArrayMessage{
+			DisplayName: proto.String(fmt.Sprintf("%d contacts", len(messages))),
+			Contacts:    messages,
+		},
+	}, nil
+}
+
+// BuildVCard generates a vCard 3.0 for a contact. Phone numbers get a waid
+// parameter so WhatsApp shows the "Message" button for them.
+func BuildVCard(contact *types.Contact) (string, error) {
+	if strings.TrimSpace(contact.Name) == "" {
+		return "", fmt.Errorf("contact name is required")
+	}
+	hasEmail := false
+	for _, email := range contact.Emails {
+		if strings.TrimSpace(email.Address) != "" {
+			hasEmail = true
+		}
+	}
+	if len(contact.Phones) == 0 && !hasEmail {
+		return "", fmt.Errorf("contact %s needs at least one phone or email", contact.Name)
+	}
+
+	var lines []string
+	lines = append(lines, "BEGIN:VCARD", "VERSION:3.0")
+	lines = append(lines, fmt.Sprintf("N:%s;%s;;;", escapeVCard(contact.LastName), escapeVCard(firstNonEmpty(contact.FirstName, nameWithoutLast(contact)))))
+	lines = append(lines, "FN:"+escapeVCard(contact.Name))
+	if contact.Organization != "" {
+		lines = append(lines, "ORG:"+escapeVCard(contact.Organization))
+	}
+	if contact.JobTitle != "" {
+		lines = append(lines, "TITLE:"+escapeVCard(contact.JobTitle))
+	}
+
+	for _, phone := range contact.Phones {
+		digits := digitsOnly(phone.Number)
+		if digits == "" {
+			return "", fmt.Errorf("invalid phone number for contact %s: %s", contact.Name, phone.Number)
+		}
+		phoneType := strings.ToUpper(firstNonEmpty(phone.Type, "CELL"))
+		lines = append(lines, fmt.Sprintf("TEL;type=%s;type=VOICE;waid=%s:+%s", escapeVCardParam(phoneType), digits, digits))
+	}
+	for _, email := range contact.Emails {
+		address := strings.TrimSpace(email.Address)
+		if address == "" {
+			continue
+		}
+		emailType := strings.ToUpper(firstNonEmpty(email.Type, "INTERNET"))
+		lines = append(lines, fmt.Sprintf("EMAIL;type=%s:%s", escapeVCardParam(emailType), escapeVCard(address)))
+	}
+	if contact.URL != "" {
+		// URIs aren't text values, so they are written unescaped
+		lines = append(lines, "URL:"+strings.Join(strings.Fields(contact.URL), ""))
+	}
+	if addr := contact.Address; addr != nil {
+		addrType := strings.ToUpper(firstNonEmpty(addr.Type, "HOME"))
+		lines = append(lines, fmt.Sprintf("ADR;type=%s:;;%s;%s;%s;%s;%s",
+			escapeVCardParam(addrType),
+			escapeVCard(addr.Street),
+			escapeVCard(addr.City),
+			escapeVCard(addr.Region),
+			escapeVCard(addr.PostalCode),
+			escapeVCard(addr.Country)))
+	}
+	lines = append(lines, "END:VCARD")
+
+	var vcard strings.Builder
+	for _, line := range lines {
+		vcard.WriteString(foldVCardLine(line))
+		vcard.WriteString("\r\n")
+	}
+	return vcard.String(), nil
+}
+
+// ParseVCards parses one or more vCards into structured contacts
+func ParseVCards(data string) []types.Contact {
+	var contacts []types.Contact
+	var current *types.Contact
+
+	for _, line := range unfoldVCard(data) {
+		name, params, value, ok := splitVCardLine(line)
+		if !ok {
+			continue
+		}
+
+		switch name {
+		case "BEGIN":
+			if strings.EqualFold(value, "VCARD") {
+				current = &types.Contact{}
+			}
+			continue
+		case "END":
+			if current != nil && strings.EqualFold(value, "VCARD") {
+				contacts = append(contacts, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		switch name {
+		case "FN":
+			current.Name = unescapeVCard(value)
+		case "N":
+			parts := splitVCardValue(value)
+			if len(parts) > 0 {
+				current.LastName = parts[0]
+			}
+			if len(parts) > 1 {
+				current.FirstName = parts[1]
+			}
+		case "ORG":
+			current.Organization = strings.Join(splitVCardValue(value), " ")
+		case "TITLE":
+			current.JobTitle = unescapeVCard(value)
+		case "TEL":
+			phone := types.ContactPhone{Number: unescapeVCard(value), Type: vcardType(params)}
+			if waid := params["WAID"]; len(waid) > 0 {
+				phone.WAID = waid[0]
+			}
+			current.Phones = append(current.Phones, phone)
+		case "EMAIL":
+			current.Emails = append(current.Emails, types.ContactEmail{Address: unescapeVCard(value), Type: vcardType(params)})
+		case "URL":
+			current.URL = unescapeVCard(value)
+		case "ADR":
+			if current.Address != nil {
+				// Only one address is kept, the first is usually the preferred one
+				continue
+			}
+			parts := splitVCardValue(value)
+			for len(parts) < 7 {
+				parts = append(parts, "")
+			}
+			current.Address = &types.ContactAddress{
+				Type:       vcardType(params),
+				Street:     parts[2],
+				City:       parts[3],
+				Region:     parts[4],
+				PostalCode: parts[5],
+				Country:    parts[6],
+			}
+		}
+	}
+	return contacts
+}
+
+// ParseContactMessages extracts structured contacts from an inbound contact
+// or contacts array message. Returns nil for other messages.
+func ParseContactMessages(msg *waE2E.Message) []types.Contact {
+	var contactMessages []*waE2E.ContactMessage
+	switch {
+	case msg.GetContactMessage() != nil:
+		contactMessages = []*waE2E.ContactMessage{msg.GetContactMessage()}
+	case msg.GetContactsArrayMessage() != nil:
+		contactMessages = msg.GetContactsArrayMessage().GetContacts()
+	default:
+		return nil
+	}
+
+	contacts := []types.Contact{}
+	for _, contactMsg := range contactMessages {
+		parsed := ParseVCards(contactMsg.GetVcard())
+		if len(parsed) == 0 {
+			parsed = []types.Contact{{}}
+		}
+		for _, contact := range parsed {
+			if contact.Name == "" {
+				contact.Name = contactMsg.GetDisplayName()
+			}
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// escapeVCard escapes a text value
+func escapeVCard(value string) string {
+	return vcardEscaper.Replace(value)
+}
+
+// escapeVCardParam strips characters that would break a parameter value
+func escapeVCardParam(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ';', ':', ',', '"', '\r', '\n':
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// unescapeVCard reverses escapeVCard
+func unescapeVCard(value string) string {
+	var out strings.Builder
+	escaped := false
+	for _, r := range value {
+		if escaped {
+			switch r {
+			case 'n', 'N':
+				out.WriteRune('\n')
+			default:
+				out.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+// splitVCardValue splits a structured value on unescaped semicolons and unescapes each part
+func splitVCardValue(value string) []string {
+	var parts []string
+	var current strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			current.WriteRune('\\')
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			parts = append(parts, unescapeVCard(current.String()))
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, unescapeVCard(current.String()))
+}
+
+// foldVCardLine folds a line into chunks of at most maxVCardLineLength
+// octets, without splitting multi-byte characters
+func foldVCardLine(line string) string {
+	if len(line) <= maxVCardLineLength {
+		return line
+	}
+
+	var folded strings.Builder
+	lineLength := 0
+	for _, r := range line {
+		size := len(string(r))
+		if lineLength+size > maxVCardLineLength {
+			folded.WriteString("\r\n ")
+			lineLength = 1
+		}
+		folded.WriteRune(r)
+		lineLength += size
+	}
+	return folded.String()
+}
+
+// unfoldVCard joins folded lines back together
+func unfoldVCard(data string) []string {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitVCardLine splits a content line into its upper-cased property name,
+// parameters and raw value. Property groups (item1.TEL) are dropped.
+func splitVCardLine(line string) (string, map[string][]string, string, bool) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", nil, "", false
+	}
+	head, value := line[:colon], line[colon+1:]
+
+	parts := strings.Split(head, ";")
+	name := strings.ToUpper(parts[0])
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	params := make(map[string][]string)
+	for _, param := range parts[1:] {
+		key, val, found := strings.Cut(param, "=")
+		if !found {
+			// vCard 2.1 style bare type, e.g. TEL;CELL:...
+			params["TYPE"] = append(params["TYPE"], strings.ToUpper(key))
+			continue
+		}
+		key = strings.ToUpper(key)
+		for _, v := range strings.Split(val, ",") {
+			params[key] = append(params[key], strings.Trim(v, `"`))
+		}
+	}
+	return name, params, value, true
+}
+
+// vcardType returns the first type parameter that isn't a generic one
+func vcardType(params map[string][]string) string {
+	for _, t := range params["TYPE"] {
+		switch strings.ToUpper(t) {
+		case "VOICE", "INTERNET", "PREF":
+			continue
+		}
+		return strings.ToUpper(t)
+	}
+	return ""
+}
+
+// nameWithoutLast is used as the given name when only a full name is known
+func nameWithoutLast(contact *types.Contact) string {
+	if contact.LastName != "" {
+		return ""
+	}
+	return contact.Name
+}
+
+// digitsOnly strips everything but digits from a phone number
+func digitsOnly(phone string) string {
+	phone, _, _ = strings.Cut(phone, "@")
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"multi-client-whatsapp/internal/types"
+)
+
+func TestVCardRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		contact types.Contact
+		want    types.Contact
+	}{
+		{
+			name: "escaped separators and newlines",
+			contact: types.Contact{
+				Name:         "Silva, Ana; CEO",
+				FirstName:    "Ana; Maria",
+				LastName:     "Silva, Jr.",
+				Organization: "Acme; Brasil, Ltda",
+				JobTitle:     "Head of\nSales",
+				Phones:       []types.ContactPhone{{Number: "+55 11 91234-5678"}},
+				Address: &types.ContactAddress{
+					Type:       "work",
+					Street:     "Av. Paulista, 1000; 5th floor\nRoom 2",
+					City:       "São Paulo",
+					Region:     "SP",
+					PostalCode: "01310-100",
+					Country:    "Brazil",
+				},
+			},
+			want: types.Contact{
+				Name:         "Silva, Ana; CEO",
+				FirstName:    "Ana; Maria",
+				LastName:     "Silva, Jr.",
+				Organization: "Acme; Brasil, Ltda",
+				JobTitle:     "Head of\nSales",
+				Phones:       []types.ContactPhone{{Number: "+5511912345678", Type: "CELL", WAID: "5511912345678"}},
+				Address: &types.ContactAddress{
+					Type:       "WORK",
+					Street:     "Av. Paulista, 1000; 5th floor\nRoom 2",
+					City:       "São Paulo",
+					Region:     "SP",
+					PostalCode: "01310-100",
+					Country:    "Brazil",
+				},
+			},
+		},
+		{
+			name: "multi-byte text is folded without splitting characters",
+			contact: types.Contact{
+				Name:      "José",
+				FirstName: "José",
+				JobTitle:  strings.Repeat("é", 40) + strings.Repeat("🎉", 30),
+				Emails:    []types.ContactEmail{{Address: "jose@example.com"}},
+			},
+			want: types.Contact{
+				Name:      "José",
+				FirstName: "José",
+				JobTitle:  strings.Repeat("é", 40) + strings.Repeat("🎉", 30),
+				Emails:    []types.ContactEmail{{Address: "jose@example.com"}},
+			},
+		},
+		{
+			name: "several phones and emails",
+			contact: types.Contact{
+				Name: "Support",
+				Phones: []types.ContactPhone{
+					{Number: "+1 (555) 010-0001", Type: "work"},
+					{Number: "5550100002", Type: "home"},
+				},
+				Emails: []types.ContactEmail{
+					{Address: "support@example.com", Type: "work"},
+					{Address: " "},
+					{Address: "help@example.com"},
+				},
+			},
+			want: types.Contact{
+				Name:      "Support",
+				FirstName: "Support",
+				Phones: []types.ContactPhone{
+					{Number: "+15550100001", Type: "WORK", WAID: "15550100001"},
+					{Number: "+5550100002", Type: "HOME", WAID: "5550100002"},
+				},
+				Emails: []types.ContactEmail{
+					{Address: "support@example.com", Type: "WORK"},
+					{Address: "help@example.com"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vcard, err := BuildVCard(&test.contact)
+			if err != nil {
+				t.Fatalf("BuildVCard() error = %v", err)
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(vcard, "\r\n"), "\r\n") {
+				if len(line) > maxVCardLineLength {
+					t.Errorf("line is %d octets, want at most %d: %q", len(line), maxVCardLineLength, line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line splits a multi-byte character: %q", line)
+				}
+			}
+
+			contacts := ParseVCards(vcard)
+			if len(contacts) != 1 {
+				t.Fatalf("ParseVCards() returned %d contacts, want 1:\n%s", len(contacts), vcard)
+			}
+			if !reflect.DeepEqual(contacts[0], test.want) {
+				t.Errorf("ParseVCards() = %+v, want %+v\n%s", contacts[0], test.want, vcard)
+			}
+		})
+	}
+}
+
+func TestParseVCardsSeveralAddresses(t *testing.T) {
+	vcard := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Ana\r\n" +
+		"TEL;type=CELL;waid=5511912345678:+55 11 91234-5678\r\n" +
+		"TEL;type=WORK:+55 11 3000-0000\r\n" +
+		"EMAIL;type=INTERNET:ana@example.com\r\n" +
+		"EMAIL;type=WORK:ana@work.example.com\r\n" +
+		"ADR;type=WORK:;;Rua A\\, 1;Campinas;SP;13000-000;Brazil\r\n" +
+		"ADR;type=HOME:;;Rua B;Santos;SP;11000-000;Brazil\r\n" +
+		"END:VCARD\r\n"
+
+	contacts := ParseVCards(vcard)
+	if len(contacts) != 1 {
+		t.Fatalf("ParseVCards() returned %d contacts, want 1", len(contacts))
+	}
+	contact := contacts[0]
+	if len(contact.Phones) != 2 || contact.Phones[0].WAID != "5511912345678" || contact.Phones[1].Type != "WORK" {
+		t.Errorf("Phones = %+v", contact.Phones)
+	}
+	if len(contact.Emails) != 2 || contact.Emails[1].Address != "ana@work.example.com" {
+		t.Errorf("Emails = %+v", contact.Emails)
+	}
+	// Contacts hold a single address, the first one is kept
+	want := &types.ContactAddress{Type: "WORK", Street: "Rua A, 1", City: "Campinas", Region: "SP", PostalCode: "13000-000", Country: "Brazil"}
+	if !reflect.DeepEqual(contact.Address, want) {
+		t.Errorf("Address = %+v, want %+v", contact.Address, want)
+	}
+}
+
+func TestBuildVCardErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		contact types.Contact
+	}{
+		{name: "no name", contact: types.Contact{Name: " ", Phones: []types.ContactPhone{{Number: "5511912345678"}}}},
+		{name: "no phone or email", contact: types.Contact{Name: "Ana"}},
+		{name: "blank emails only", contact: types.Contact{Name: "Ana", Emails: []types.ContactEmail{{Address: ""}, {Address: "  "}}}},
+		{name: "phone without digits", contact: types.Contact{Name: "Ana", Phones: []types.ContactPhone{{Number: "n/a"}}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := BuildVCard(&test.contact); err == nil {
+				t.Error("BuildVCard() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -11,10 +11,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"multi-client-whatsapp/internal/instance"
 	"multi-client-whatsapp/internal/types"
+	"multi-client-whatsapp/pkg/webhooksig"
 
 	"github.com/google/uuid"
 	"go.mau.fi/whatsmeow"
@@ -34,8 +36,33 @@ func init() {
 	}
 }
 
-// SendWebhook sends webhook data to Node.js with instance information
+// SendWebhook sends webhook data with instance information to the instance's webhook destinations
 func SendWebhook(eventType string, data interface{}, instanceKey string) {
+	SendWebhookTo(WebhookDestinations(instanceKey), eventType, data, instanceKey)
+}
+
+// SendWebhookTo sends webhook data with instance information to the given
+// destinations, for events outliving the instance's configuration
+func SendWebhookTo(destinations []types.WebhookDestination, eventType string, data interface{}, instanceKey string) {
+	sendEventWebhook(destinations, eventType, data, instanceKey, nil)
+}
+
+// decryptedPollVote is the outcome of decrypting and tallying a poll vote,
+// which happens before the webhook whether or not a destination wants it
+type decryptedPollVote struct {
+	Vote *types.PollVote
+	Err  error
+}
+
+// sendEventWebhook sends the webhook of an event, with the poll vote the
+// event handler already decrypted if it is one
+func sendEventWebhook(destinations []types.WebhookDestination, eventType string, data interface{}, instanceKey string, pollVote *decryptedPollVote) {
+	// Skip downloading media for events no destination subscribes to
+	destinations = subscribedWebhookDestinations(destinations, eventType, data)
+	if len(destinations) == 0 {
+		return
+	}
+
 	// Check if this is a media message and download if needed
 	var enhancedData interface{}
 
@@ -43,12 +70,44 @@ func SendWebhook(eventType string, data interface{}, instanceKey string) {
 		// Start with the full raw event data
 		enhancedData = data
 
+		// Look through ephemeral and view once wrappers so their media is found
+		msgEvent = unwrapMessageEvent(msgEvent)
+
 		// Check for media and download if present
 		instance.Manager.Mutex.RLock()
 		inst, exists := instance.Manager.Instances[instanceKey]
 		instance.Manager.Mutex.RUnlock()
 
-		if exists && inst.Client != nil {
+		if reply := ParseInteractiveReply(msgEvent.Message); reply != nil {
+			// Button and list replies carry the tapped option as typed data
+			replyData := messageEventData(msgEvent)
+			replyData[reply.Type] = reply
+			enhancedData = replyData
+		} else if msgEvent.Message.GetPollUpdateMessage() != nil && pollVote != nil {
+			// Poll votes are encrypted, attach the selected options the event handler decrypted
+			voteData := messageEventData(msgEvent)
+			if pollVote.Err != nil {
+				voteData["poll_vote_error"] = pollVote.Err.Error()
+			} else {
+				voteData["poll_vote"] = pollVote.Vote
+			}
+			enhancedData = voteData
+		} else if location := ParseLocation(msgEvent.Message); location != nil {
+			// Locations carry the parsed coordinates so receivers don't need to dig through the raw proto
+			locationData := messageEventData(msgEvent)
+			locationData["location"] = location
+			enhancedData = locationData
+		} else if contacts := ParseContactMessages(msgEvent.Message); contacts != nil {
+			// Contact cards carry their vCards parsed into structured contacts
+			contactData := messageEventData(msgEvent)
+			contactData["contacts"] = contacts
+			enhancedData = contactData
+		} else if status := ParseTextStatus(msgEvent); status != nil {
+			// Text statuses carry their text, colours and font
+			statusData := messageEventData(msgEvent)
+			statusData["status"] = status
+			enhancedData = statusData
+		} else if exists && inst.Client != nil {
 			ctx := context.Background()
 
 			// Check for different media types and download them
@@ -169,6 +228,7 @@ func SendWebhook(eventType string, data interface{}, instanceKey string) {
 		Instance:  instanceKey,
 		Timestamp: time.Now(),
 		Data:      enhancedData,
+		Metadata:  LookupMessageMetadata(instanceKey, webhookMessageIDs(data)),
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -177,15 +237,56 @@ func SendWebhook(eventType string, data interface{}, instanceKey string) {
 		return
 	}
 
-	// Send to Node.js webhook receiver
-	resp, err := http.Post("http://webhook-receiver:5555/webhook", "application/json", bytes.NewBuffer(jsonData))
+	// Post to every destination at once, so a slow receiver doesn't delay the others
+	var wg sync.WaitGroup
+	for _, destination := range destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postWebhook(destination, eventType, jsonData, instanceKey)
+		}()
+	}
+	wg.Wait()
+}
+
+// postWebhook posts a webhook payload to one destination
+func postWebhook(destination types.WebhookDestination, eventType string, jsonData []byte, instanceKey string) {
+	req, err := http.NewRequest(http.MethodPost, destination.URL, bytes.NewReader(jsonData))
+	if err != nil {
+		log.Printf("Error sending webhook for instance %s to %s: %v", instanceKey, destination.URL, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(destination.Secrets) > 0 {
+		// Signed over the timestamp too, so receivers can reject replays
+		webhooksig.SetHeaders(req.Header, destination.Secrets, time.Now(), jsonData)
+	}
+
+	resp, err := webhookHTTPClient.Do(req)
 	if err != nil {
-		log.Printf("Error sending webhook for instance %s: %v", instanceKey, err)
+		log.Printf("Error sending webhook for instance %s to %s: %v", instanceKey, destination.URL, err)
 		return
 	}
 	defer resp.Body.Close()
 
-	log.Printf("Webhook sent for instance %s: %s", instanceKey, eventType)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Webhook for instance %s rejected by %s: %d", instanceKey, destination.URL, resp.StatusCode)
+		return
+	}
+	log.Printf("Webhook sent for instance %s to %s: %s", instanceKey, destination.URL, eventType)
+}
+
+// messageEventData returns the common webhook fields for a message event
+func messageEventData(msgEvent *events.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"raw_event":     msgEvent,
+		"message":       msgEvent.Message,
+		"info":          msgEvent.Info,
+		"source_string": msgEvent.Info.SourceString(),
+		"push_name":     msgEvent.Info.PushName,
+		"is_from_me":    msgEvent.Info.IsFromMe,
+		"is_group":      msgEvent.Info.Chat.Server == "g.us",
+	}
 }
 
 // downloadMedia downloads media from WhatsApp and saves it to the media volume
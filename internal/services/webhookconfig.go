@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"multi-client-whatsapp/internal/instance"
+	"multi-client-whatsapp/internal/types"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Webhook destination limits. A destination signs with at most two secrets,
+// the new and the old one while a secret is rotated.
+const (
+	MaxWebhookDestinations = 10
+	MaxWebhookSecrets      = 2
+	minWebhookSecretLength = 16
+)
+
+// defaultWebhookURL receives the webhooks of instances without their own
+// destinations, subscribed to defaultWebhookEvents or all events and signed
+// with defaultWebhookSecrets if there are any
+var (
+	defaultWebhookURL     = "http://webhook-receiver:5555/webhook"
+	defaultWebhookEvents  []string
+	defaultWebhookSecrets []string
+)
+
+// webhookHTTPClient posts webhooks, the timeout keeps a dead receiver from
+// holding up the instance's event handling
+var webhookHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+func init() {
+	if value := os.Getenv("WEBHOOK_URL"); value != "" {
+		defaultWebhookURL = value
+	}
+	for _, eventType := range strings.Split(os.Getenv("WEBHOOK_EVENTS"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			if !webhookEventTypes[eventType] {
+				log.Printf("Warning: WEBHOOK_EVENTS has unknown event type %q, it never matches", eventType)
+			}
+			defaultWebhookEvents = append(defaultWebhookEvents, eventType)
+		}
+	}
+	for _, secret := range strings.Split(os.Getenv("WEBHOOK_SECRET"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			defaultWebhookSecrets = append(defaultWebhookSecrets, secret)
+		}
+	}
+	log.Printf("Default webhook destination: %s", defaultWebhookURL)
+}
+
+// ValidateWebhookDestinations checks the destinations an instance's webhooks
+// are posted to, and normalizes the JIDs of their filters
+func ValidateWebhookDestinations(destinations []types.WebhookDestination) error {
+	if len(destinations) > MaxWebhookDestinations {
+		return fmt.Errorf("%w: at most %d webhooks per instance", ErrInvalidWebhook, MaxWebhookDestinations)
+	}
+	seen := map[string]bool{}
+	for i, destination := range destinations {
+		parsed, err := url.Parse(destination.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%w: webhook %d needs an absolute http or https url", ErrInvalidWebhook, i)
+		}
+		if seen[destination.URL] {
+			return fmt.Errorf("%w: webhook %d duplicates %s", ErrInvalidWebhook, i, destination.URL)
+		}
+		seen[destination.URL] = true
+
+		if len(destination.Secrets) > MaxWebhookSecrets {
+			return fmt.Errorf("%w: webhook %d can have at most %d secrets", ErrInvalidWebhook, i, MaxWebhookSecrets)
+		}
+		for _, secret := range destination.Secrets {
+			if len(secret) < minWebhookSecretLength {
+				return fmt.Errorf("%w: webhook %d: secrets need at least %d characters", ErrInvalidWebhook, i, minWebhookSecretLength)
+			}
+		}
+
+		if err := validateWebhookSubscription(i, &destinations[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWebhookConfig returns the webhook destinations of an instance, or the
+// global default when it has none of its own. Secrets are masked.
+func GetWebhookConfig(inst *types.Instance) *types.WebhookConfig {
+	inst.Mutex.RLock()
+	custom := len(inst.Webhooks) > 0
+	inst.Mutex.RUnlock()
+
+	config := &types.WebhookConfig{InstanceKey: inst.ID, Default: !custom}
+	for _, destination := range InstanceWebhookDestinations(inst) {
+		masked := make([]string, len(destination.Secrets))
+		for i, secret := range destination.Secrets {
+			masked[i] = maskWebhookSecret(secret)
+		}
+		if len(masked) > 0 {
+			destination.Secrets = masked
+		}
+		config.Webhooks = append(config.Webhooks, destination)
+	}
+	return config
+}
+
+// SetWebhookDestinations replaces the webhook destinations of an instance,
+// none makes it use the global default again
+func SetWebhookDestinations(inst *types.Instance, destinations []types.WebhookDestination) *types.WebhookConfig {
+	inst.Mutex.Lock()
+	inst.Webhooks = append([]types.WebhookDestination(nil), destinations...)
+	inst.Mutex.Unlock()
+	return GetWebhookConfig(inst)
+}
+
+// InstanceWebhookDestinations returns where the webhooks of an instance are
+// posted, the global default when it has no destinations of its own
+func InstanceWebhookDestinations(inst *types.Instance) []types.WebhookDestination {
+	inst.Mutex.RLock()
+	destinations := append([]types.WebhookDestination(nil), inst.Webhooks...)
+	inst.Mutex.RUnlock()
+
+	if len(destinations) == 0 {
+		return defaultWebhookDestinations()
+	}
+	return destinations
+}
+
+// WebhookDestinations returns where the webhooks of an instance are posted.
+// Instances that are gone use the global default.
+func WebhookDestinations(instanceKey string) []types.WebhookDestination {
+	instance.Manager.Mutex.RLock()
+	inst, exists := instance.Manager.Instances[instanceKey]
+	instance.Manager.Mutex.RUnlock()
+
+	if !exists {
+		return defaultWebhookDestinations()
+	}
+	return InstanceWebhookDestinations(inst)
+}
+
+func defaultWebhookDestinations() []types.WebhookDestination {
+	return []types.WebhookDestination{{URL: defaultWebhookURL, Events: defaultWebhookEvents, Secrets: defaultWebhookSecrets}}
+}
+
+// maskWebhookSecret hides a secret in API responses, leaving its last
+// characters so the secrets of a rotation can be told apart
+func maskWebhookSecret(secret string) string {
+	if len(secret) < minWebhookSecretLength {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
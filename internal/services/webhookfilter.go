@@ -0,0 +1,216 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"multi-client-whatsapp/internal/types"
+
+	whatsappTypes "go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// webhookChatTypes are the chat types a webhook destination can filter on
+var webhookChatTypes = map[string]bool{
+	"direct":     true,
+	"group":      true,
+	"status":     true,
+	"newsletter": true,
+	"broadcast":  true,
+}
+
+// webhookEventTypes are the event types webhooks are sent for: those of
+// GetEventType plus the instance lifecycle and API events. Keep it in sync
+// when adding an event type.
+var webhookEventTypes = map[string]bool{
+	"app_state_sync_complete":        true,
+	"app_state_update":               true,
+	"audio_received":                 true,
+	"chat_presence_update":           true,
+	"connected":                      true,
+	"contact_received":               true,
+	"disconnected":                   true,
+	"document_received":              true,
+	"group_info_update":              true,
+	"history_sync":                   true,
+	"image_received":                 true,
+	"instance_connected":             true,
+	"instance_deleted":               true,
+	"instance_disconnected":          true,
+	"instance_manually_connected":    true,
+	"instance_manually_disconnected": true,
+	"interactive_message_received":   true,
+	"list_received":                  true,
+	"live_location_received":         true,
+	"location_received":              true,
+	"logged_out":                     true,
+	"message_deleted":                true,
+	"message_delivered":              true,
+	"message_edited":                 true,
+	"message_error":                  true,
+	"message_played":                 true,
+	"message_played_self":            true,
+	"message_read":                   true,
+	"message_read_self":              true,
+	"message_receipt":                true,
+	"message_received":               true,
+	"message_retry":                  true,
+	"message_revoked":                true,
+	"message_sender_receipt":         true,
+	"message_sent":                   true,
+	"order_received":                 true,
+	"pair_success":                   true,
+	"poll_vote":                      true,
+	"presence_update":                true,
+	"push_name_setting":              true,
+	"push_name_update":               true,
+	"status_received":                true,
+	"sticker_received":               true,
+	"stream_replaced":                true,
+	"unknown_event":                  true,
+	"video_received":                 true,
+}
+
+// validateWebhookSubscription checks the events and filters of a webhook
+// destination and normalizes its JID lists, so they compare with event JIDs
+func validateWebhookSubscription(i int, destination *types.WebhookDestination) error {
+	var unknown []string
+	for _, eventType := range destination.Events {
+		if strings.TrimSpace(eventType) == "" {
+			return fmt.Errorf("%w: webhook %d has an empty event type", ErrInvalidWebhook, i)
+		}
+		if !webhookEventTypes[eventType] {
+			unknown = append(unknown, eventType)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: webhook %d: unknown event types: %s", ErrInvalidWebhook, i, strings.Join(unknown, ", "))
+	}
+
+	filter := destination.Filters
+	if filter == nil {
+		return nil
+	}
+	for _, chatType := range filter.ChatTypes {
+		if !webhookChatTypes[chatType] {
+			return fmt.Errorf("%w: webhook %d: unknown chat type %q, use direct, group, status, newsletter or broadcast", ErrInvalidWebhook, i, chatType)
+		}
+	}
+	var err error
+	if filter.IncludeJIDs, err = normalizeFilterJIDs(filter.IncludeJIDs); err != nil {
+		return fmt.Errorf("%w: webhook %d: include_jids: %v", ErrInvalidWebhook, i, err)
+	}
+	if filter.ExcludeJIDs, err = normalizeFilterJIDs(filter.ExcludeJIDs); err != nil {
+		return fmt.Errorf("%w: webhook %d: exclude_jids: %v", ErrInvalidWebhook, i, err)
+	}
+	return nil
+}
+
+// normalizeFilterJIDs parses the JIDs of a filter list, bare phone numbers
+// are taken as user JIDs
+func normalizeFilterJIDs(list []string) ([]string, error) {
+	normalized := make([]string, 0, len(list))
+	for _, value := range list {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "@") {
+			value = strings.TrimPrefix(value, "+") + "@" + whatsappTypes.DefaultUserServer
+		}
+		jid, err := whatsappTypes.ParseJID(value)
+		if err != nil || jid.User == "" {
+			return nil, fmt.Errorf("invalid JID %q", value)
+		}
+		normalized = append(normalized, jid.ToNonAD().String())
+	}
+	return normalized, nil
+}
+
+// subscribedWebhookDestinations returns the destinations that subscribe to an event
+func subscribedWebhookDestinations(destinations []types.WebhookDestination, eventType string, data interface{}) []types.WebhookDestination {
+	source, hasSource := webhookEventSource(data)
+
+	var subscribed []types.WebhookDestination
+	for _, destination := range destinations {
+		if len(destination.Events) > 0 && !slices.Contains(destination.Events, eventType) {
+			continue
+		}
+		if destination.Filters != nil && hasSource && !webhookFilterMatches(destination.Filters, source) {
+			continue
+		}
+		subscribed = append(subscribed, destination)
+	}
+	return subscribed
+}
+
+// webhookFilterMatches reports whether an event about a chat passes a destination's filter
+func webhookFilterMatches(filter *types.WebhookFilter, source whatsappTypes.MessageSource) bool {
+	if len(filter.ChatTypes) > 0 && !slices.Contains(filter.ChatTypes, webhookChatType(source.Chat)) {
+		return false
+	}
+	if filter.IsFromMe != nil && *filter.IsFromMe != source.IsFromMe {
+		return false
+	}
+
+	// Senders are matched by both their phone number and LID address
+	var jids []string
+	for _, jid := range []whatsappTypes.JID{source.Chat, source.Sender, source.SenderAlt, source.RecipientAlt} {
+		if !jid.IsEmpty() {
+			jids = append(jids, jid.ToNonAD().String())
+		}
+	}
+	for _, jid := range jids {
+		if slices.Contains(filter.ExcludeJIDs, jid) {
+			return false
+		}
+	}
+	if len(filter.IncludeJIDs) == 0 {
+		return true
+	}
+	for _, jid := range jids {
+		if slices.Contains(filter.IncludeJIDs, jid) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEventSource returns the chat and sender of events about a chat
+func webhookEventSource(data interface{}) (whatsappTypes.MessageSource, bool) {
+	switch evt := data.(type) {
+	case *events.Message:
+		return evt.Info.MessageSource, true
+	case *events.UndecryptableMessage:
+		return evt.Info.MessageSource, true
+	case *events.Receipt:
+		return evt.MessageSource, true
+	case *events.ChatPresence:
+		return evt.MessageSource, true
+	case *events.DeleteForMe:
+		return whatsappTypes.MessageSource{Chat: evt.ChatJID, Sender: evt.SenderJID, IsFromMe: evt.IsFromMe}, true
+	case *events.Presence:
+		return whatsappTypes.MessageSource{Chat: evt.From, Sender: evt.From}, true
+	case *events.GroupInfo:
+		source := whatsappTypes.MessageSource{Chat: evt.JID, IsGroup: true}
+		if evt.Sender != nil {
+			source.Sender = *evt.Sender
+		}
+		return source, true
+	}
+	return whatsappTypes.MessageSource{}, false
+}
+
+// webhookChatType classifies a chat for webhook filters
+func webhookChatType(chat whatsappTypes.JID) string {
+	switch {
+	case chat == whatsappTypes.StatusBroadcastJID:
+		return "status"
+	case chat.Server == whatsappTypes.GroupServer:
+		return "group"
+	case chat.Server == whatsappTypes.NewsletterServer:
+		return "newsletter"
+	case chat.Server == whatsappTypes.BroadcastServer:
+		return "broadcast"
+	default:
+		return "direct"
+	}
+}
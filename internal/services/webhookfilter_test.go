@@ -0,0 +1,39 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"multi-client-whatsapp/internal/types"
+)
+
+func TestValidateWebhookEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []string
+		unknown []string
+	}{
+		{name: "all events"},
+		{name: "known events", events: []string{"message_received", "message_read", "instance_deleted", "poll_vote"}},
+		{name: "typos", events: []string{"message_recieved", "message_read", "Message_Read"}, unknown: []string{"message_recieved", "Message_Read"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateWebhookDestinations([]types.WebhookDestination{{URL: "https://example.com/hook", Events: test.events}})
+			if len(test.unknown) == 0 {
+				if err != nil {
+					t.Fatalf("ValidateWebhookDestinations() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidWebhook) {
+				t.Fatalf("ValidateWebhookDestinations() error = %v, want %v", err, ErrInvalidWebhook)
+			}
+			if !strings.HasSuffix(err.Error(), "unknown event types: "+strings.Join(test.unknown, ", ")) {
+				t.Errorf("error %q doesn't list %v", err, test.unknown)
+			}
+		})
+	}
+}
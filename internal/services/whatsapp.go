@@ -12,15 +12,52 @@ import (
 
 // HandleInstanceEvents handles events for a specific instance
 func HandleInstanceEvents(instanceKey string, evt interface{}) {
-	// Determine event type and send appropriate webhook
-	eventType := GetEventType(evt)
-	SendWebhook(eventType, evt, instanceKey)
-
-	// Handle connection events - check for successful login
 	instance.Manager.Mutex.RLock()
 	inst, exists := instance.Manager.Instances[instanceKey]
 	instance.Manager.Mutex.RUnlock()
 
+	// Cache the message for quoting and tally poll votes before the webhook,
+	// so webhook subscriptions and filters don't decide what state is kept
+	var pollVote *decryptedPollVote
+	if msgEvent, ok := evt.(*events.Message); ok && exists {
+		CacheReceivedMessage(instanceKey, msgEvent)
+
+		if voteEvent := unwrapMessageEvent(msgEvent); voteEvent.Message.GetPollUpdateMessage() != nil && inst.Client != nil {
+			vote, err := RecordPollVote(inst, voteEvent)
+			if err != nil {
+				log.Printf("Error decrypting poll vote for instance %s: %v", instanceKey, err)
+			}
+			pollVote = &decryptedPollVote{Vote: vote, Err: err}
+		}
+	}
+
+	// Determine event type and send appropriate webhook
+	eventType := GetEventType(evt)
+	sendEventWebhook(WebhookDestinations(instanceKey), eventType, evt, instanceKey, pollVote)
+
+	// Send read receipts right away in auto-read mode or remember the
+	// message so it can be marked as read through the API
+	if msgEvent, ok := evt.(*events.Message); ok && exists {
+
+		inst.Mutex.RLock()
+		autoRead := inst.AutoRead
+		inst.Mutex.RUnlock()
+
+		if autoRead {
+			if err := MarkEventRead(inst, msgEvent); err != nil {
+				log.Printf("Error auto-marking message %s as read for instance %s: %v", msgEvent.Info.ID, instanceKey, err)
+			}
+		} else {
+			TrackUnreadMessage(instanceKey, msgEvent)
+		}
+	}
+
+	// Track delivery of sent and campaign messages
+	if receipt, ok := evt.(*events.Receipt); ok {
+		go RecordDeliveryReceipt(instanceKey, receipt)
+		go RecordCampaignReceipt(receipt)
+	}
+
 	if exists && inst.Client.IsLoggedIn() {
 		inst.Mutex.Lock()
 		inst.IsConnected = true
@@ -70,6 +107,8 @@ func HandleInstanceEvents(instanceKey string, evt interface{}) {
 func GetEventType(evt interface{}) string {
 	switch e := evt.(type) {
 	case *events.Message:
+		e = unwrapMessageEvent(e)
+
 		// Check for protocol messages (revoke, edit, etc.)
 		if protocolMsg := e.Message.GetProtocolMessage(); protocolMsg != nil {
 			switch protocolMsg.GetType().String() {
@@ -80,6 +119,20 @@ func GetEventType(evt interface{}) string {
 			}
 		}
 
+		// Status posts of contacts, whatever their content
+		if IsStatusMessage(e) {
+			return "status_received"
+		}
+
+		if e.Message.GetPollUpdateMessage() != nil {
+			return "poll_vote"
+		}
+
+		// Check for taps on buttons and list rows of interactive messages
+		if reply := ParseInteractiveReply(e.Message); reply != nil {
+			return reply.Type
+		}
+
 		// Check message content type
 		if e.Message.GetConversation() != "" || e.Message.GetExtendedTextMessage() != nil {
 			return "message_received"
@@ -99,7 +152,7 @@ func GetEventType(evt interface{}) string {
 		if e.Message.GetStickerMessage() != nil {
 			return "sticker_received"
 		}
-		if e.Message.GetContactMessage() != nil {
+		if e.Message.GetContactMessage() != nil || e.Message.GetContactsArrayMessage() != nil {
 			return "contact_received"
 		}
 		if e.Message.GetLocationMessage() != nil {
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
 
@@ -16,6 +17,8 @@ type Instance struct {
 	IsConnected bool
 	QRCodeChan  chan string
 	Container   *sqlstore.Container
+	AutoRead    bool                 // Automatically send read receipts for inbound messages
+	Webhooks    []WebhookDestination // Where the instance's webhooks are posted, empty means the global default
 	Mutex       sync.RWMutex
 }
 
@@ -27,11 +30,42 @@ type InstanceManager struct {
 
 // WebhookPayload represents the webhook data sent to Node.js
 type WebhookPayload struct {
-	Event     string      `json:"event"`
-	EventType string      `json:"event_type"`
-	Instance  string      `json:"instance"`
-	Timestamp time.Time   `json:"timestamp"`
-	Data      interface{} `json:"data"`
+	Event     string                     `json:"event"`
+	EventType string                     `json:"event_type"`
+	Instance  string                     `json:"instance"`
+	Timestamp time.Time                  `json:"timestamp"`
+	Data      interface{}                `json:"data"`
+	Metadata  map[string]json.RawMessage `json:"metadata,omitempty"` // Metadata given when sending the messages the event is about, by message ID
+}
+
+// WebhookDestination is a URL an instance's webhooks are posted to, with
+// the events it subscribes to
+type WebhookDestination struct {
+	URL     string         `json:"url" binding:"required"`
+	Events  []string       `json:"events,omitempty"`  // Event types to post, empty means all
+	Filters *WebhookFilter `json:"filters,omitempty"` // Only post events about matching chats
+	Secrets []string       `json:"secrets,omitempty"` // HMAC signing secrets, two while rotating
+}
+
+// WebhookFilter narrows the chat events a webhook destination receives.
+// Events that aren't about a chat, like connection events, aren't filtered.
+type WebhookFilter struct {
+	ChatTypes   []string `json:"chat_types,omitempty"`   // "direct", "group", "status", "newsletter" or "broadcast"
+	IsFromMe    *bool    `json:"is_from_me,omitempty"`   // Only the instance's own messages, or only others'
+	IncludeJIDs []string `json:"include_jids,omitempty"` // Only these chats or senders
+	ExcludeJIDs []string `json:"exclude_jids,omitempty"` // Never these chats or senders
+}
+
+// WebhookConfigRequest replaces the webhook destinations of an instance
+type WebhookConfigRequest struct {
+	Webhooks []WebhookDestination `json:"webhooks" binding:"required,dive"`
+}
+
+// WebhookConfig is the webhook configuration of an instance
+type WebhookConfig struct {
+	InstanceKey string               `json:"instance_key"`
+	Webhooks    []WebhookDestination `json:"webhooks"`
+	Default     bool                 `json:"default"` // The instance uses the global default destination
 }
 
 // ConnectRequest represents the request to connect a new instance
@@ -48,63 +82,287 @@ type ConnectResponse struct {
 
 // MessageRequest represents a message sending request
 type MessageRequest struct {
-	InstanceKey string `json:"instance_key" binding:"required"`
-	Phone       string `json:"phone" binding:"required"`
-	Message     string `json:"message" binding:"required"`
-	ReplyTo     string `json:"reply_to,omitempty"`
+	InstanceKey         string       `json:"instance_key" binding:"required"`
+	Phone               string       `json:"phone" binding:"required_without=To"`
+	To                  string       `json:"to,omitempty"` // Any JID: phone, LID, group or newsletter. Takes precedence over phone
+	Message             string       `json:"message" binding:"required"`
+	ReplyTo             string       `json:"reply_to,omitempty"`
+	SimulateTyping      bool         `json:"simulate_typing,omitempty"`      // Show "typing..." for a while before sending
+	Mentions            []string     `json:"mentions,omitempty"`             // Phones/JIDs mentioned with @number in the message
+	MentionAll          bool         `json:"mention_all,omitempty"`          // Mention every group participant
+	LinkPreview         bool         `json:"link_preview,omitempty"`         // Generate a preview for the first URL in the message
+	Preview             *LinkPreview `json:"preview,omitempty"`              // Custom preview data, used instead of fetching the URL
+	EphemeralExpiration uint32       `json:"ephemeral_expiration,omitempty"` // Disappearing message timer in seconds, should match the chat's timer
+	CustomMessageID     string       `json:"custom_message_id,omitempty"`    // WhatsApp message ID to send with instead of a generated one
+}
+
+// LinkPreview represents the preview shown for a URL in a text message
+type LinkPreview struct {
+	URL          string `json:"url,omitempty"` // Defaults to the first URL in the message
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	Thumbnail    string `json:"thumbnail,omitempty"` // Base64 encoded image, takes precedence over thumbnail_url
 }
 
 // MediaMessageRequest represents a media message sending request
 type MediaMessageRequest struct {
-	InstanceKey string `json:"instance_key" binding:"required"`
-	Phone       string `json:"phone" binding:"required"`
-	Caption     string `json:"caption,omitempty"`
-	URL         string `json:"url" binding:"required"`
-	Type        string `json:"type" binding:"required"` // "image", "audio", "video", "file"
-	IsPTT       bool   `json:"is_ptt,omitempty"`        // For audio: true = voice recording, false = audio file
+	InstanceKey         string   `json:"instance_key" binding:"required"`
+	Phone               string   `json:"phone" binding:"required_without=To"`
+	To                  string   `json:"to,omitempty"`
+	Caption             string   `json:"caption,omitempty"`
+	URL                 string   `json:"url" binding:"required"`
+	Type                string   `json:"type" binding:"required"` // "image", "audio", "video", "file"
+	IsPTT               bool     `json:"is_ptt,omitempty"`        // For audio: true = voice recording, false = audio file
+	ReplyTo             string   `json:"reply_to,omitempty"`
+	Mentions            []string `json:"mentions,omitempty"`    // Phones/JIDs mentioned with @number in the caption
+	MentionAll          bool     `json:"mention_all,omitempty"` // Mention every group participant
+	EphemeralExpiration uint32   `json:"ephemeral_expiration,omitempty"`
+	ViewOnce            bool     `json:"view_once,omitempty"` // Images, videos and voice notes (audio with is_ptt) only
+	CustomMessageID     string   `json:"custom_message_id,omitempty"`
 }
 
 // VoiceMessageRequest represents a voice recording message sending request
 type VoiceMessageRequest struct {
-	InstanceKey string `json:"instance_key" binding:"required"`
-	Phone       string `json:"phone" binding:"required"`
-	URL         string `json:"url" binding:"required"`
-	ReplyTo     string `json:"reply_to,omitempty"`
+	InstanceKey         string `json:"instance_key" binding:"required"`
+	Phone               string `json:"phone" binding:"required_without=To"`
+	To                  string `json:"to,omitempty"`
+	URL                 string `json:"url" binding:"required"`
+	ReplyTo             string `json:"reply_to,omitempty"`
+	EphemeralExpiration uint32 `json:"ephemeral_expiration,omitempty"`
+	ViewOnce            bool   `json:"view_once,omitempty"`
+	CustomMessageID     string `json:"custom_message_id,omitempty"`
+}
+
+// AlbumMessageRequest represents an album sending request
+type AlbumMessageRequest struct {
+	InstanceKey         string      `json:"instance_key" binding:"required"`
+	Phone               string      `json:"phone" binding:"required_without=To"`
+	To                  string      `json:"to,omitempty"`
+	Items               []AlbumItem `json:"items" binding:"required"` // 2 to 30 images and videos
+	ReplyTo             string      `json:"reply_to,omitempty"`
+	EphemeralExpiration uint32      `json:"ephemeral_expiration,omitempty"`
+	CustomMessageID     string      `json:"custom_message_id,omitempty"` // Used for the parent album message
+}
+
+// AlbumItem is an image or video of an album
+type AlbumItem struct {
+	URL     string `json:"url"`
+	Type    string `json:"type"` // "image" or "video"
+	Caption string `json:"caption,omitempty"`
+}
+
+// AlbumItemError is an album item that couldn't be sent
+type AlbumItemError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// AlbumMessageResponse lists the messages an album was sent as
+type AlbumMessageResponse struct {
+	Status     string           `json:"status"`      // "sent" or "partial"
+	MessageID  string           `json:"message_id"`  // Same as parent_id
+	ParentID   string           `json:"parent_id"`   // The album message the items are attached to
+	MessageIDs []string         `json:"message_ids"` // Items in album order, "" for items that failed
+	Failed     []AlbumItemError `json:"failed,omitempty"`
 }
 
 // LocationMessageRequest represents a location message sending request
 type LocationMessageRequest struct {
-	InstanceKey string  `json:"instance_key" binding:"required"`
-	Phone       string  `json:"phone" binding:"required"`
-	Latitude    float64 `json:"latitude" binding:"required"`
-	Longitude   float64 `json:"longitude" binding:"required"`
-	ReplyTo     string  `json:"reply_to,omitempty"`
+	InstanceKey         string  `json:"instance_key" binding:"required"`
+	Phone               string  `json:"phone" binding:"required_without=To"`
+	To                  string  `json:"to,omitempty"`
+	Latitude            float64 `json:"latitude" binding:"required"`
+	Longitude           float64 `json:"longitude" binding:"required"`
+	Name                string  `json:"name,omitempty"`
+	Address             string  `json:"address,omitempty"`
+	URL                 string  `json:"url,omitempty"`
+	ThumbnailURL        string  `json:"thumbnail_url,omitempty"`
+	Thumbnail           string  `json:"thumbnail,omitempty"` // Base64 encoded image, takes precedence over thumbnail_url
+	AccuracyInMeters    uint32  `json:"accuracy_in_meters,omitempty"`
+	Live                bool    `json:"live,omitempty"`          // Share a live location instead of a fixed pin
+	LiveDuration        int     `json:"live_duration,omitempty"` // Seconds the live location can be updated for, defaults to 15 minutes
+	Caption             string  `json:"caption,omitempty"`       // Live location only
+	ReplyTo             string  `json:"reply_to,omitempty"`
+	EphemeralExpiration uint32  `json:"ephemeral_expiration,omitempty"`
+	CustomMessageID     string  `json:"custom_message_id,omitempty"`
+}
+
+// LiveLocationUpdateRequest represents an update to a live location share
+type LiveLocationUpdateRequest struct {
+	InstanceKey      string  `json:"instance_key" binding:"required"`
+	MessageID        string  `json:"message_id" binding:"required"` // ID of the live location message returned when sharing started
+	Latitude         float64 `json:"latitude" binding:"required"`
+	Longitude        float64 `json:"longitude" binding:"required"`
+	AccuracyInMeters uint32  `json:"accuracy_in_meters,omitempty"`
+	SpeedInMps       float32 `json:"speed_in_mps,omitempty"`
+	Heading          uint32  `json:"heading,omitempty"` // Degrees clockwise from magnetic north
+	Caption          string  `json:"caption,omitempty"`
+}
+
+// LiveLocationResponse represents the response from sharing or updating a live location
+type LiveLocationResponse struct {
+	Status         string     `json:"status"`
+	MessageID      string     `json:"message_id"`
+	UpdateID       string     `json:"update_id,omitempty"`
+	SequenceNumber int64      `json:"sequence_number"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // Only set when the share starts
+}
+
+// LocationData represents the parsed content of an inbound location or live location
+type LocationData struct {
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	Name             string  `json:"name,omitempty"`
+	Address          string  `json:"address,omitempty"`
+	URL              string  `json:"url,omitempty"`
+	Comment          string  `json:"comment,omitempty"`
+	Caption          string  `json:"caption,omitempty"`
+	AccuracyInMeters uint32  `json:"accuracy_in_meters,omitempty"`
+	SpeedInMps       float32 `json:"speed_in_mps,omitempty"`
+	Heading          uint32  `json:"heading,omitempty"`
+	SequenceNumber   int64   `json:"sequence_number,omitempty"`
+	TimeOffset       uint32  `json:"time_offset,omitempty"` // Seconds since the live location share started
+	IsLive           bool    `json:"is_live"`
 }
 
-// ContactMessageRequest represents a contact message sending request
+// ContactMessageRequest represents a contact message sending request. Either
+// contact_name/contact_phone for a single simple contact or contacts is required.
 type ContactMessageRequest struct {
-	InstanceKey  string `json:"instance_key" binding:"required"`
-	Phone        string `json:"phone" binding:"required"`
-	ContactName  string `json:"contact_name" binding:"required"`
-	ContactPhone string `json:"contact_phone" binding:"required"`
-	ReplyTo      string `json:"reply_to,omitempty"`
+	InstanceKey         string    `json:"instance_key" binding:"required"`
+	Phone               string    `json:"phone" binding:"required_without=To"`
+	To                  string    `json:"to,omitempty"`
+	ContactName         string    `json:"contact_name,omitempty"`
+	ContactPhone        string    `json:"contact_phone,omitempty"`
+	Contacts            []Contact `json:"contacts,omitempty"` // Several contacts are sent as one contacts array message
+	ReplyTo             string    `json:"reply_to,omitempty"`
+	EphemeralExpiration uint32    `json:"ephemeral_expiration,omitempty"`
+	CustomMessageID     string    `json:"custom_message_id,omitempty"`
+}
+
+// Contact is a structured contact card, used both for sending and for parsed inbound vCards
+type Contact struct {
+	Name         string          `json:"name"` // Full display name
+	FirstName    string          `json:"first_name,omitempty"`
+	LastName     string          `json:"last_name,omitempty"`
+	Organization string          `json:"organization,omitempty"`
+	JobTitle     string          `json:"job_title,omitempty"`
+	Phones       []ContactPhone  `json:"phones,omitempty"`
+	Emails       []ContactEmail  `json:"emails,omitempty"`
+	URL          string          `json:"url,omitempty"`
+	Address      *ContactAddress `json:"address,omitempty"`
+}
+
+// ContactPhone is a phone number of a contact card
+type ContactPhone struct {
+	Number string `json:"number"`
+	Type   string `json:"type,omitempty"` // CELL (default), HOME, WORK, ...
+	WAID   string `json:"waid,omitempty"` // WhatsApp ID of the number, only set on inbound contacts
+}
+
+// ContactEmail is an email address of a contact card
+type ContactEmail struct {
+	Address string `json:"address"`
+	Type    string `json:"type,omitempty"` // INTERNET (default), HOME, WORK
+}
+
+// ContactAddress is a postal address of a contact card
+type ContactAddress struct {
+	Type       string `json:"type,omitempty"` // HOME (default) or WORK
+	Street     string `json:"street,omitempty"`
+	City       string `json:"city,omitempty"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
 }
 
 // InteractiveMessageRequest represents an interactive message sending request
 type InteractiveMessageRequest struct {
-	InstanceKey string   `json:"instance_key" binding:"required"`
-	Phone       string   `json:"phone" binding:"required"`
-	Title       string   `json:"title" binding:"required"`
-	Body        string   `json:"body" binding:"required"`
-	Footer      string   `json:"footer,omitempty"`
-	Buttons     []Button `json:"buttons" binding:"required"`
-	ReplyTo     string   `json:"reply_to,omitempty"`
+	InstanceKey         string        `json:"instance_key" binding:"required"`
+	Phone               string        `json:"phone" binding:"required_without=To"`
+	To                  string        `json:"to,omitempty"`
+	Type                string        `json:"type,omitempty"` // "buttons" (default), "list" or "native_flow"
+	Title               string        `json:"title" binding:"required"`
+	Body                string        `json:"body" binding:"required"`
+	Footer              string        `json:"footer,omitempty"`
+	Buttons             []Button      `json:"buttons,omitempty"`     // For "buttons" and "native_flow"
+	ButtonText          string        `json:"button_text,omitempty"` // For "list": text of the button that opens the list
+	Sections            []ListSection `json:"sections,omitempty"`    // For "list"
+	ReplyTo             string        `json:"reply_to,omitempty"`
+	EphemeralExpiration uint32        `json:"ephemeral_expiration,omitempty"`
+	CustomMessageID     string        `json:"custom_message_id,omitempty"`
 }
 
 // Button represents a button in an interactive message
 type Button struct {
-	ID    string `json:"id" binding:"required"`
-	Title string `json:"title" binding:"required"`
+	ID          string `json:"id"`
+	Title       string `json:"title" binding:"required"`
+	Type        string `json:"type,omitempty"`         // "reply" (default), or for native_flow also "url", "call", "copy"
+	URL         string `json:"url,omitempty"`          // For "url" buttons
+	PhoneNumber string `json:"phone_number,omitempty"` // For "call" buttons
+	CopyCode    string `json:"copy_code,omitempty"`    // For "copy" buttons
+}
+
+// ListSection represents a section of rows in a list message
+type ListSection struct {
+	Title string    `json:"title"`
+	Rows  []ListRow `json:"rows"`
+}
+
+// ListRow represents a selectable row in a list message
+type ListRow struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// InteractiveReply represents a user tapping a button or list row
+type InteractiveReply struct {
+	Type            string `json:"type"` // "button_reply" or "list_reply"
+	ID              string `json:"id"`
+	Title           string `json:"title"`
+	Description     string `json:"description,omitempty"`
+	QuotedMessageID string `json:"quoted_message_id,omitempty"` // ID of the interactive message that was answered
+}
+
+// PollMessageRequest represents a poll creation request
+type PollMessageRequest struct {
+	InstanceKey         string   `json:"instance_key" binding:"required"`
+	Phone               string   `json:"phone" binding:"required_without=To"`
+	To                  string   `json:"to,omitempty"`
+	Question            string   `json:"question" binding:"required"`
+	Options             []string `json:"options" binding:"required"`
+	SelectableCount     int      `json:"selectable_count,omitempty"` // How many options a voter may pick, 0 = any number
+	ReplyTo             string   `json:"reply_to,omitempty"`
+	EphemeralExpiration uint32   `json:"ephemeral_expiration,omitempty"`
+	CustomMessageID     string   `json:"custom_message_id,omitempty"`
+}
+
+// PollVote represents a decrypted vote on a poll
+type PollVote struct {
+	PollID          string     `json:"poll_id"`
+	Voter           string     `json:"voter"`
+	SelectedOptions []string   `json:"selected_options"`         // Empty when the voter removed their vote
+	UnknownHashes   []string   `json:"unknown_hashes,omitempty"` // Option hashes that couldn't be matched to a name
+	Timestamp       time.Time  `json:"timestamp"`
+	Tally           *PollTally `json:"tally,omitempty"`
+}
+
+// PollTally represents the aggregated results of a poll
+type PollTally struct {
+	PollID      string            `json:"poll_id"`
+	Chat        string            `json:"chat"`
+	Question    string            `json:"question"`
+	Options     []PollOptionTally `json:"options"`
+	TotalVoters int               `json:"total_voters"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// PollOptionTally represents the votes for a single poll option
+type PollOptionTally struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
 }
 
 // MessageResponse represents the response from message sending
@@ -114,6 +372,84 @@ type MessageResponse struct {
 	Error     string `json:"error,omitempty"`
 }
 
+// RevokeMessageRequest represents a request to revoke (delete for everyone) messages
+type RevokeMessageRequest struct {
+	InstanceKey string         `json:"instance_key" binding:"required"`
+	Phone       string         `json:"phone" binding:"required"` // Chat the messages belong to (phone, LID or group JID)
+	Messages    []RevokeTarget `json:"messages" binding:"required,min=1,dive"`
+}
+
+// RevokeTarget represents a single message to be revoked
+type RevokeTarget struct {
+	MessageID   string `json:"message_id" binding:"required"`
+	Participant string `json:"participant,omitempty"` // Sender of the message, only for admin revokes in groups
+	Timestamp   int64  `json:"timestamp,omitempty"`   // Unix time the message was sent, used to check the revoke window
+}
+
+// RevokeResult represents the outcome of revoking a single message
+type RevokeResult struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	RevokeID  string `json:"revoke_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// RevokeMessageResponse represents the response from the revoke endpoint
+type RevokeMessageResponse struct {
+	Status  string         `json:"status"`
+	Results []RevokeResult `json:"results"`
+}
+
+// MarkReadRequest represents a request to mark messages in a chat as read.
+// When no message IDs are given, every tracked unread message up to UpTo
+// (or now, if UpTo is not set) is marked as read.
+type MarkReadRequest struct {
+	InstanceKey string   `json:"instance_key" binding:"required"`
+	Phone       string   `json:"phone" binding:"required"`
+	MessageIDs  []string `json:"message_ids,omitempty"`
+	Sender      string   `json:"sender,omitempty"` // Sender of the messages, needed in groups for messages the bridge didn't track
+	UpTo        int64    `json:"up_to,omitempty"`  // Unix timestamp
+}
+
+// MarkReadResponse represents the response from the mark read endpoint
+type MarkReadResponse struct {
+	Status     string   `json:"status"`
+	Chat       string   `json:"chat"`
+	MessageIDs []string `json:"message_ids"`
+	Count      int      `json:"count"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// AutoReadRequest represents a request to toggle automatic read receipts
+type AutoReadRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ChatPresenceRequest represents a request to send a typing/recording indicator to a chat
+type ChatPresenceRequest struct {
+	InstanceKey string `json:"instance_key" binding:"required"`
+	Phone       string `json:"phone" binding:"required"`
+	State       string `json:"state" binding:"required"` // "composing", "recording" or "paused"
+}
+
+// DisappearingTimerRequest sets the disappearing messages timer of a chat or group
+type DisappearingTimerRequest struct {
+	InstanceKey string `json:"instance_key" binding:"required"`
+	Phone       string `json:"phone" binding:"required"` // Phone number or group JID
+	Timer       string `json:"timer" binding:"required"` // "off", "24h", "7d" or "90d"
+}
+
+// DefaultDisappearingTimerRequest sets the timer new chats of the instance start with
+type DefaultDisappearingTimerRequest struct {
+	Timer string `json:"timer" binding:"required"` // "off", "24h", "7d" or "90d"
+}
+
+// PresenceRequest represents a request to set the instance's online status
+type PresenceRequest struct {
+	InstanceKey string `json:"instance_key" binding:"required"`
+	State       string `json:"state" binding:"required"` // "available" or "unavailable"
+}
+
 // IncomingMessage represents an incoming WhatsApp message
 type IncomingMessage struct {
 	InstanceKey string    `json:"instance_key"`
@@ -162,3 +498,303 @@ type ExtractedMedia struct {
 	Caption   string `json:"caption"`
 	URL       string `json:"url"`
 }
+
+// ScheduledMessage is a send request stored to be sent later, once or on a recurrence
+type ScheduledMessage struct {
+	ID             string          `json:"id"`
+	InstanceKey    string          `json:"instance_key"`
+	Endpoint       string          `json:"endpoint"` // Send endpoint the payload is replayed against, e.g. /message/send
+	Payload        json.RawMessage `json:"payload"`
+	SendAt         time.Time       `json:"send_at"`   // Next time the message is sent
+	StartsAt       time.Time       `json:"starts_at"` // Requested send_at, the start of the recurrence
+	Recurrence     string          `json:"recurrence,omitempty"`
+	Timezone       string          `json:"timezone"`
+	Status         string          `json:"status"` // "pending", "sent", "completed", "failed" or "cancelled"
+	Attempts       int             `json:"attempts"`
+	OfflineRetries int             `json:"offline_retries"` // Retries of the current occurrence while the instance was offline
+	RunCount       int             `json:"run_count"`
+	LastError      string          `json:"last_error,omitempty"`
+	LastMessageID  string          `json:"last_message_id,omitempty"`
+	LastQueueID    string          `json:"last_queue_id,omitempty"` // Outbound queue item of the last run
+	LastRunAt      *time.Time      `json:"last_run_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// ScheduleRequest holds the scheduling fields accepted by every send endpoint
+type ScheduleRequest struct {
+	InstanceKey string     `json:"instance_key"`
+	SendAt      *time.Time `json:"send_at,omitempty"`
+	Recurrence  string     `json:"recurrence,omitempty"` // Cron expression or RRULE
+	Timezone    string     `json:"timezone,omitempty"`   // IANA zone the recurrence is evaluated in, defaults to UTC
+}
+
+// ScheduleResponse is returned by send endpoints when a message was scheduled instead of sent
+type ScheduleResponse struct {
+	Status     string    `json:"status"`
+	ScheduleID string    `json:"schedule_id"`
+	SendAt     time.Time `json:"send_at"`
+	Recurrence string    `json:"recurrence,omitempty"`
+}
+
+// UpdateScheduledMessageRequest changes a pending scheduled message, omitted fields are kept
+type UpdateScheduledMessageRequest struct {
+	SendAt     *time.Time      `json:"send_at,omitempty"`
+	Recurrence *string         `json:"recurrence,omitempty"` // Empty string turns it into a one-off message
+	Timezone   *string         `json:"timezone,omitempty"`
+	Payload    json.RawMessage `json:"payload,omitempty"` // Replaces the message body
+}
+
+// QueuedMessage is a send request waiting in (or processed by) an instance's outbound queue
+type QueuedMessage struct {
+	ID            string          `json:"id"`
+	InstanceKey   string          `json:"instance_key"`
+	Endpoint      string          `json:"endpoint"`
+	Payload       json.RawMessage `json:"payload"`
+	Priority      string          `json:"priority"` // "high", "normal" or "low"
+	Status        string          `json:"status"`   // "pending", "sending", "sent", "failed" or "cancelled"
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	Response      json.RawMessage `json:"response,omitempty"` // Response of the send endpoint
+	MessageID     string          `json:"message_id,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	SentAt        *time.Time      `json:"sent_at,omitempty"`
+}
+
+// MessageMetadataRequest holds the tracking fields accepted by every send endpoint
+type MessageMetadataRequest struct {
+	InstanceKey     string          `json:"instance_key"`
+	Metadata        json.RawMessage `json:"metadata,omitempty"` // Free-form object echoed in later webhooks about the message
+	CustomMessageID string          `json:"custom_message_id,omitempty"`
+}
+
+// QueueRequest holds the queueing fields accepted by every send endpoint
+type QueueRequest struct {
+	InstanceKey string `json:"instance_key"`
+	Async       bool   `json:"async,omitempty"`    // Return right away with the queue ID instead of waiting for the send
+	Priority    string `json:"priority,omitempty"` // "high", "normal" (default) or "low"
+}
+
+// QueueResponse is returned by send endpoints when a message was queued
+type QueueResponse struct {
+	Status   string `json:"status"`
+	QueueID  string `json:"queue_id"`
+	Priority string `json:"priority"`
+}
+
+// QueueSettings are the pacing settings of an instance's outbound queue
+type QueueSettings struct {
+	InstanceKey   string `json:"instance_key"`
+	RatePerMinute int    `json:"rate_per_minute"` // 0 means unlimited
+	JitterMs      int    `json:"jitter_ms"`       // Random extra delay before each send
+	DailyCap      int    `json:"daily_cap"`       // Maximum sends per UTC day, 0 means unlimited
+	Default       bool   `json:"default"`         // The instance uses the global defaults
+	SentToday     int    `json:"sent_today"`
+	Pending       int    `json:"pending"`
+}
+
+// QueueSettingsRequest changes the pacing settings of an instance, omitted fields are kept
+type QueueSettingsRequest struct {
+	RatePerMinute *int `json:"rate_per_minute,omitempty"`
+	JitterMs      *int `json:"jitter_ms,omitempty"`
+	DailyCap      *int `json:"daily_cap,omitempty"`
+}
+
+// IdempotencyRecord is the stored outcome of a send request made with an idempotency key
+type IdempotencyRecord struct {
+	InstanceKey  string          `json:"instance_key"`
+	Key          string          `json:"key"`
+	RequestHash  string          `json:"request_hash"` // SHA-256 of the canonical request body
+	Status       string          `json:"status"`       // "processing" or "completed"
+	ResponseCode int             `json:"response_code"`
+	Response     json.RawMessage `json:"response"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+}
+
+// CampaignRequest creates a bulk campaign. The message is a send request
+// template whose string values can use {{variable}} placeholders, filled in
+// per recipient.
+type CampaignRequest struct {
+	Name          string                   `json:"name" binding:"required"`
+	InstanceKey   string                   `json:"instance_key,omitempty"`
+	InstanceKeys  []string                 `json:"instance_keys,omitempty"` // Pool of instances to spread the campaign over
+	MessageType   string                   `json:"message_type"`            // "text" (default), "media", "contact", "voice", "location", "interactive" or "poll"
+	Message       json.RawMessage          `json:"message"`                 // Body of the send endpoint, without instance_key and phone
+	Recipients    []CampaignRecipientInput `json:"recipients,omitempty"`
+	RecipientsCSV string                   `json:"recipients_csv,omitempty"` // CSV with a "phone" column, other columns become variables
+	StartAt       *time.Time               `json:"start_at,omitempty"`
+	Priority      string                   `json:"priority,omitempty"` // Outbound queue lane, defaults to "low"
+}
+
+// CampaignRecipientInput is a recipient of a campaign with its template variables
+type CampaignRecipientInput struct {
+	Phone     string            `json:"phone"`
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// Campaign is a bulk send to a list of recipients
+type Campaign struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	InstanceKeys []string        `json:"instance_keys"`
+	MessageType  string          `json:"message_type"`
+	Message      json.RawMessage `json:"message"`
+	Priority     string          `json:"priority"`
+	Status       string          `json:"status"` // "scheduled", "running", "paused", "completed" or "cancelled"
+	StartAt      time.Time       `json:"start_at"`
+	Stats        CampaignStats   `json:"stats"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	StartedAt    *time.Time      `json:"started_at,omitempty"`
+	CompletedAt  *time.Time      `json:"completed_at,omitempty"`
+}
+
+// CampaignStats counts the recipients of a campaign by status
+type CampaignStats struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Queued    int `json:"queued"`
+	Sent      int `json:"sent"`
+	Delivered int `json:"delivered"`
+	Read      int `json:"read"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+}
+
+// CampaignRecipient is the send status of one campaign recipient
+type CampaignRecipient struct {
+	ID            int64             `json:"id"`
+	Phone         string            `json:"phone"`
+	ResolvedPhone string            `json:"resolved_phone,omitempty"` // Number after validation and correction
+	Variables     map[string]string `json:"variables,omitempty"`
+	Status        string            `json:"status"` // "pending", "queued", "sent", "delivered", "read", "failed" or "cancelled"
+	InstanceKey   string            `json:"instance_key,omitempty"`
+	QueueID       string            `json:"queue_id,omitempty"`
+	MessageID     string            `json:"message_id,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// TemplateRequest creates a message template or a new version of it. The
+// message is the body of the send endpoint for the type, its string values
+// can use {{variable}} placeholders.
+type TemplateRequest struct {
+	Name        string          `json:"name,omitempty"` // Taken from the URL when adding a version
+	Type        string          `json:"type"`           // "text", "media", "contact" or "location"
+	Message     json.RawMessage `json:"message"`
+	Description string          `json:"description,omitempty"`
+}
+
+// MessageTemplate is one version of a named message template
+type MessageTemplate struct {
+	Name        string          `json:"name"`
+	Version     int             `json:"version"`
+	Type        string          `json:"type"`
+	Message     json.RawMessage `json:"message"`
+	Variables   []string        `json:"variables"` // Placeholders used in the message
+	Description string          `json:"description,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// TemplateMessageRequest sends a rendered message template
+type TemplateMessageRequest struct {
+	InstanceKey     string            `json:"instance_key" binding:"required"`
+	Phone           string            `json:"phone" binding:"required_without=To"`
+	To              string            `json:"to,omitempty"`
+	Template        string            `json:"template" binding:"required"`
+	Version         int               `json:"version,omitempty"` // Defaults to the latest version
+	Variables       map[string]string `json:"variables,omitempty"`
+	ReplyTo         string            `json:"reply_to,omitempty"`
+	CustomMessageID string            `json:"custom_message_id,omitempty"`
+}
+
+// TextStatusRequest posts a text status
+type TextStatusRequest struct {
+	InstanceKey     string   `json:"instance_key" binding:"required"`
+	Text            string   `json:"text" binding:"required"`
+	BackgroundColor string   `json:"background_color,omitempty"` // #RRGGBB or #AARRGGBB
+	TextColor       string   `json:"text_color,omitempty"`       // #RRGGBB or #AARRGGBB
+	Font            string   `json:"font,omitempty"`             // "system", "system_text", "fb_script", "system_bold", "morningbreeze_regular", "calistoga_regular", "exo2_extrabold" or "courierprime_bold"
+	Audience        []string `json:"audience,omitempty"`         // Not supported yet, statuses go to the account's status privacy list
+	CustomMessageID string   `json:"custom_message_id,omitempty"`
+}
+
+// MediaStatusRequest posts an image or video status
+type MediaStatusRequest struct {
+	InstanceKey     string   `json:"instance_key" binding:"required"`
+	Type            string   `json:"type" binding:"required"` // "image" or "video"
+	URL             string   `json:"url" binding:"required"`
+	Caption         string   `json:"caption,omitempty"`
+	Audience        []string `json:"audience,omitempty"` // Not supported yet, statuses go to the account's status privacy list
+	CustomMessageID string   `json:"custom_message_id,omitempty"`
+}
+
+// TextStatus is the content of an inbound text status post
+type TextStatus struct {
+	Text            string `json:"text"`
+	BackgroundColor string `json:"background_color,omitempty"`
+	TextColor       string `json:"text_color,omitempty"`
+	Font            string `json:"font,omitempty"`
+}
+
+// StatusPrivacy is one of the account's status privacy lists
+type StatusPrivacy struct {
+	Type      string   `json:"type"` // "contacts", "blacklist" or "whitelist"
+	List      []string `json:"list,omitempty"`
+	IsDefault bool     `json:"is_default"`
+}
+
+// ForwardMessageRequest forwards a recent message to one or more chats
+type ForwardMessageRequest struct {
+	InstanceKey     string   `json:"instance_key" binding:"required"`
+	MessageID       string   `json:"message_id" binding:"required"`
+	Chat            string   `json:"chat" binding:"required"`     // Chat the message is in (phone or group JID)
+	To              []string `json:"to" binding:"required"`       // Chats to forward to (phones or group JIDs)
+	CustomMessageID string   `json:"custom_message_id,omitempty"` // Only with a single target
+}
+
+// ForwardResult is the outcome of forwarding to one chat
+type ForwardResult struct {
+	To        string `json:"to"`
+	Status    string `json:"status"` // "sent" or "failed"
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ForwardMessageResponse lists the forwarding results per target chat
+type ForwardMessageResponse struct {
+	Status  string          `json:"status"` // "sent", "partial" or "failed"
+	Results []ForwardResult `json:"results"`
+}
+
+// MessageStatus is the delivery status of a message the bridge sent. For
+// groups the status is the furthest any participant got, see Participants
+// for each of them.
+type MessageStatus struct {
+	InstanceKey  string                     `json:"instance_key"`
+	MessageID    string                     `json:"message_id"`
+	Chat         string                     `json:"chat"`
+	Status       string                     `json:"status"` // "sent", "server_ack", "delivered", "read" or "played"
+	SentAt       time.Time                  `json:"sent_at"`
+	ServerAckAt  *time.Time                 `json:"server_ack_at,omitempty"`
+	DeliveredAt  *time.Time                 `json:"delivered_at,omitempty"`
+	ReadAt       *time.Time                 `json:"read_at,omitempty"`
+	PlayedAt     *time.Time                 `json:"played_at,omitempty"` // Voice notes and videos only
+	UpdatedAt    time.Time                  `json:"updated_at"`
+	Metadata     json.RawMessage            `json:"metadata,omitempty"`
+	Participants []ParticipantMessageStatus `json:"participants,omitempty"`
+}
+
+// ParticipantMessageStatus is the delivery status of a group message for one participant
+type ParticipantMessageStatus struct {
+	Participant string     `json:"participant"`
+	Status      string     `json:"status"` // "delivered", "read" or "played"
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+	PlayedAt    *time.Time `json:"played_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -22,9 +22,12 @@ func GenerateInstanceKey() string {
 
 // ParseJIDWithLIDSupport parses a JID with support for both @s.whatsapp.net and @lid
 func ParseJIDWithLIDSupport(phone string, instance *types.Instance) (whatsappTypes.JID, error) {
-	// First try to parse as regular JID
-	if jid, err := whatsappTypes.ParseJID(phone); err == nil {
-		return jid, nil
+	// First try to parse as regular JID. Bare numbers are skipped here since
+	// ParseJID would treat them as a server without a user.
+	if strings.Contains(phone, "@") {
+		if jid, err := whatsappTypes.ParseJID(phone); err == nil {
+			return jid, nil
+		}
 	}
 
 	// Check if it's a LID format (ends with @lid)
@@ -0,0 +1,143 @@
+// Package webhooksig signs the webhooks of the WhatsApp bridge and lets
+// receivers verify them.
+//
+// Each payload is signed with HMAC-SHA256 over "<timestamp>.<body>", where the
+// timestamp is the Unix time in the X-Webhook-Timestamp header. The
+// X-Webhook-Signature header carries one "sha256=<hex>" signature per active
+// secret, separated by commas, so a destination's secret can be rotated
+// without dropping webhooks: the bridge signs with both the new and the old
+// secret until the old one is removed.
+//
+// A receiver verifies a request with VerifyRequest:
+//
+//	body, err := webhooksig.VerifyRequest(r, webhooksig.DefaultTolerance, os.Getenv("WEBHOOK_SECRET"))
+//	if err != nil {
+//		http.Error(w, "invalid signature", http.StatusUnauthorized)
+//		return
+//	}
+package webhooksig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a signed webhook
+const (
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// DefaultTolerance is how far a webhook's timestamp may be from the receiver's
+// clock, older webhooks are rejected as replays
+const DefaultTolerance = 5 * time.Minute
+
+// MaxBodySize caps the body VerifyRequest reads. Webhooks are JSON events,
+// media isn't sent inline, so anything larger isn't from the bridge.
+const MaxBodySize = 10 << 20
+
+const signaturePrefix = "sha256="
+
+var (
+	ErrMissingSignature  = errors.New("webhook signature or timestamp missing")
+	ErrInvalidTimestamp  = errors.New("invalid webhook timestamp")
+	ErrTimestampExpired  = errors.New("webhook timestamp outside the tolerance")
+	ErrSignatureMismatch = errors.New("webhook signature doesn't match")
+	ErrNoSecrets         = errors.New("no webhook secret to verify with")
+	ErrBodyTooLarge      = errors.New("webhook body larger than MaxBodySize")
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of a payload sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetHeaders signs a payload with every secret and sets the signature and
+// timestamp headers of the request carrying it
+func SetHeaders(header http.Header, secrets []string, timestamp time.Time, body []byte) {
+	unix := timestamp.Unix()
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		signatures = append(signatures, signaturePrefix+Sign(secret, unix, body))
+	}
+	header.Set(HeaderTimestamp, strconv.FormatInt(unix, 10))
+	header.Set(HeaderSignature, strings.Join(signatures, ","))
+}
+
+// Verify checks the signature and timestamp headers of a webhook against its
+// body. Any of the secrets may have signed it, so receivers can rotate too.
+// A tolerance of 0 skips the replay check.
+func Verify(header http.Header, body []byte, tolerance time.Duration, secrets ...string) error {
+	// An unset secret must not verify payloads signed with an empty key
+	secrets = slices.DeleteFunc(slices.Clone(secrets), func(secret string) bool { return secret == "" })
+	if len(secrets) == 0 {
+		return ErrNoSecrets
+	}
+	timestampHeader := header.Get(HeaderTimestamp)
+	signatureHeader := header.Get(HeaderSignature)
+	if timestampHeader == "" || signatureHeader == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	if tolerance > 0 {
+		age := time.Since(time.Unix(timestamp, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrTimestampExpired
+		}
+	}
+
+	for _, signature := range strings.Split(signatureHeader, ",") {
+		signature, ok := strings.CutPrefix(strings.TrimSpace(signature), signaturePrefix)
+		if !ok {
+			continue
+		}
+		given, err := hex.DecodeString(signature)
+		if err != nil {
+			continue
+		}
+		for _, secret := range secrets {
+			expected, _ := hex.DecodeString(Sign(secret, timestamp, body))
+			if hmac.Equal(given, expected) {
+				return nil
+			}
+		}
+	}
+	return ErrSignatureMismatch
+}
+
+// VerifyRequest reads and verifies the body of a webhook request, at most
+// MaxBodySize bytes. The body is returned and also left readable on the request.
+func VerifyRequest(r *http.Request, tolerance time.Duration, secrets ...string) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxBodySize {
+		r.Body.Close()
+		return nil, ErrBodyTooLarge
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := Verify(r.Header, body, tolerance, secrets...); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,185 @@
+package webhooksig
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	newSecret = "new-secret-0123456789"
+	oldSecret = "old-secret-9876543210"
+)
+
+var testBody = []byte(`{"event":"message_received","instance_key":"demo"}`)
+
+func signedHeader(secrets []string, timestamp time.Time, body []byte) http.Header {
+	header := http.Header{}
+	SetHeaders(header, secrets, timestamp, body)
+	return header
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		secrets []string
+		want    error
+	}{
+		{
+			name:    "round trip",
+			header:  signedHeader([]string{newSecret}, now, testBody),
+			body:    testBody,
+			secrets: []string{newSecret},
+		},
+		{
+			name:    "rotation verifies with the new secret",
+			header:  signedHeader([]string{newSecret, oldSecret}, now, testBody),
+			body:    testBody,
+			secrets: []string{newSecret},
+		},
+		{
+			name:    "rotation verifies with the old secret",
+			header:  signedHeader([]string{newSecret, oldSecret}, now, testBody),
+			body:    testBody,
+			secrets: []string{oldSecret},
+		},
+		{
+			name:    "receiver rotating its own secrets",
+			header:  signedHeader([]string{oldSecret}, now, testBody),
+			body:    testBody,
+			secrets: []string{newSecret, oldSecret},
+		},
+		{
+			name:    "wrong secret",
+			header:  signedHeader([]string{newSecret}, now, testBody),
+			body:    testBody,
+			secrets: []string{oldSecret},
+			want:    ErrSignatureMismatch,
+		},
+		{
+			name:    "tampered body",
+			header:  signedHeader([]string{newSecret}, now, testBody),
+			body:    bytes.Replace(testBody, []byte("demo"), []byte("evil"), 1),
+			secrets: []string{newSecret},
+			want:    ErrSignatureMismatch,
+		},
+		{
+			name: "tampered timestamp",
+			header: func() http.Header {
+				header := signedHeader([]string{newSecret}, now, testBody)
+				header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix()+1, 10))
+				return header
+			}(),
+			body:    testBody,
+			secrets: []string{newSecret},
+			want:    ErrSignatureMismatch,
+		},
+		{
+			name:    "expired timestamp",
+			header:  signedHeader([]string{newSecret}, now.Add(-DefaultTolerance-time.Minute), testBody),
+			body:    testBody,
+			secrets: []string{newSecret},
+			want:    ErrTimestampExpired,
+		},
+		{
+			name:    "future timestamp",
+			header:  signedHeader([]string{newSecret}, now.Add(DefaultTolerance+time.Minute), testBody),
+			body:    testBody,
+			secrets: []string{newSecret},
+			want:    ErrTimestampExpired,
+		},
+		{
+			name: "invalid timestamp",
+			header: func() http.Header {
+				header := signedHeader([]string{newSecret}, now, testBody)
+				header.Set(HeaderTimestamp, "yesterday")
+				return header
+			}(),
+			body:    testBody,
+			secrets: []string{newSecret},
+			want:    ErrInvalidTimestamp,
+		},
+		{
+			name:    "missing headers",
+			header:  http.Header{},
+			body:    testBody,
+			secrets: []string{newSecret},
+			want:    ErrMissingSignature,
+		},
+		{
+			name:    "no secrets",
+			header:  signedHeader([]string{newSecret}, now, testBody),
+			body:    testBody,
+			secrets: nil,
+			want:    ErrNoSecrets,
+		},
+		{
+			name:    "empty secret doesn't verify a payload signed with an empty key",
+			header:  signedHeader([]string{""}, now, testBody),
+			body:    testBody,
+			secrets: []string{""},
+			want:    ErrNoSecrets,
+		},
+		{
+			name:    "empty secret is skipped next to a real one",
+			header:  signedHeader([]string{""}, now, testBody),
+			body:    testBody,
+			secrets: []string{"", newSecret},
+			want:    ErrSignatureMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.header, test.body, DefaultTolerance, test.secrets...)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("Verify() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutTolerance(t *testing.T) {
+	header := signedHeader([]string{newSecret}, time.Now().Add(-24*time.Hour), testBody)
+	if err := Verify(header, testBody, 0, newSecret); err != nil {
+		t.Fatalf("Verify() with no tolerance = %v, want nil", err)
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(testBody))
+	SetHeaders(r.Header, []string{newSecret}, time.Now(), testBody)
+
+	body, err := VerifyRequest(r, DefaultTolerance, newSecret)
+	if err != nil {
+		t.Fatalf("VerifyRequest() = %v, want nil", err)
+	}
+	if !bytes.Equal(body, testBody) {
+		t.Fatalf("VerifyRequest() body = %q, want %q", body, testBody)
+	}
+	// The body stays readable for the receiver's handler
+	again, err := io.ReadAll(r.Body)
+	if err != nil || !bytes.Equal(again, testBody) {
+		t.Fatalf("request body after VerifyRequest = %q, %v, want %q", again, err, testBody)
+	}
+}
+
+func TestVerifyRequestTooLarge(t *testing.T) {
+	body := []byte(strings.Repeat("a", MaxBodySize+1))
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	SetHeaders(r.Header, []string{newSecret}, time.Now(), body)
+
+	if _, err := VerifyRequest(r, DefaultTolerance, newSecret); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("VerifyRequest() = %v, want %v", err, ErrBodyTooLarge)
+	}
+}